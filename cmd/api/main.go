@@ -8,12 +8,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	nethttp "net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"talk-backend/internal/config"
 	"talk-backend/internal/container"
 	"talk-backend/internal/db"
 	"talk-backend/internal/http"
-	"time"
 
 	_ "talk-backend/docs"
 
@@ -22,7 +27,10 @@ import (
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
 
 	if cfg.App.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -41,22 +49,71 @@ func main() {
 		log.Println("database migrations completed")
 	}
 
-	app := container.New(cfg, gdb)
+	app, err := container.New(cfg, gdb)
+	if err != nil {
+		log.Fatalf("cannot build application: %v", err)
+	}
 
 	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		log.Fatalf("invalid trusted proxies: %v", err)
+	}
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://127.0.0.1:3000"},
+		AllowOrigins:     cfg.CORS.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Authorization", "Content-Type"},
 		ExposeHeaders:    []string{"Authorization"},
 		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+		MaxAge:           cfg.CORS.MaxAge,
 	}))
+	http.RegisterRoutes(r, app, cfg.HTTP.MaxBodySize)
+
+	srv := &nethttp.Server{
+		Addr:              ":" + cfg.App.Port,
+		Handler:           r,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+
+	// Hijacked WebSockets and SSE streams are not drained by Shutdown on its
+	// own; GoAway closes them with 1001 so clients reconnect elsewhere.
+	srv.RegisterOnShutdown(app.GoAway)
 
-	http.RegisterRoutes(r, app, cfg.JWT.Secret)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on :%s (%s)", cfg.App.Port, cfg.App.Env)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Starting server on :%s (%s)", cfg.App.Port, cfg.App.Env)
-	if err := r.Run(":" + cfg.App.Port); err != nil {
+	select {
+	case err := <-serveErr:
 		log.Fatalf("server failed: %v", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away.
+	stop()
+
+	log.Printf("shutting down, waiting up to %s", cfg.HTTP.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		log.Printf("realtime shutdown: %v", err)
+	}
+	if sqlDB, err := gdb.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			log.Printf("closing database: %v", err)
+		}
 	}
+	log.Println("server stopped")
 }
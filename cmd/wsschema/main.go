@@ -0,0 +1,21 @@
+// Command wsschema prints the JSON Schema of the WebSocket protocol so the
+// web client can generate its types from it:
+//
+//	go run ./cmd/wsschema > talk.v1.schema.json
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"talk-backend/internal/ws"
+)
+
+func main() {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ws.Schema()); err != nil {
+		log.Fatal(err)
+	}
+}
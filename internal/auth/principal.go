@@ -0,0 +1,17 @@
+package auth
+
+import "time"
+
+// Principal is the caller an access token was issued to.
+type Principal struct {
+	UserID string
+	// SessionID is the session the token belongs to. Tokens signed before
+	// sessions existed have none.
+	SessionID string
+	// Version is the user's token version when the token was issued.
+	Version int
+	// Scopes narrow what the token may be used for; none means the full
+	// access of the user.
+	Scopes    []string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidToken = errors.New("invalid access token")
+
+var uuidV4LikeRe = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[1-5][a-fA-F0-9]{3}-[89abAB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$`)
+
+// algorithms are the signing methods access tokens may use.
+var algorithms = []string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}
+
+// Claims are the claims of an access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+	Version   int    `json:"ver"`
+	// Scope is a space-separated list, as in OAuth 2.0.
+	Scope string `json:"scope,omitempty"`
+}
+
+type Config struct {
+	Issuer   string
+	Audience string
+	// Leeway absorbs clock skew between the signer and this server when
+	// checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// Verifier checks access tokens for both HTTP requests and realtime
+// connections, so they accept exactly the same tokens.
+type Verifier struct {
+	keyfunc jwt.Keyfunc
+	parser  *jwt.Parser
+}
+
+// NewVerifier returns a Verifier that takes verification keys from keyfunc.
+func NewVerifier(keyfunc jwt.Keyfunc, cfg Config) *Verifier {
+	return &Verifier{
+		keyfunc: keyfunc,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(algorithms),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithLeeway(cfg.Leeway),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+		),
+	}
+}
+
+// Verify checks the signature and claims of raw and returns who it was
+// issued to. It does not check revocation; see service.TokenGuard.
+func (v *Verifier) Verify(raw string) (*Principal, error) {
+	var claims Claims
+	if _, err := v.parser.ParseWithClaims(raw, &claims, v.keyfunc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if !uuidV4LikeRe.MatchString(claims.Subject) {
+		return nil, fmt.Errorf("%w: subject is not a user ID", ErrInvalidToken)
+	}
+	if claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: missing iat", ErrInvalidToken)
+	}
+
+	return &Principal{
+		UserID:    claims.Subject,
+		SessionID: claims.SessionID,
+		Version:   claims.Version,
+		Scopes:    strings.Fields(claims.Scope),
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
@@ -1,21 +1,122 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	App       AppConfig
-	DB        DBConfig
-	Migration Migration
-	JWT       JWTConfig
+	App        AppConfig
+	HTTP       HTTPConfig
+	CORS       CORSConfig
+	DB         DBConfig
+	Migration  Migration
+	JWT        JWTConfig
+	Auth       AuthConfig
+	Chat       ChatConfig
+	Attachment AttachmentConfig
+	Storage    StorageConfig
+	WS         WSConfig
+}
+
+type HTTPConfig struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds how long a stopping server waits for requests
+	// and realtime connections to drain.
+	ShutdownTimeout time.Duration
+	// MaxBodySize caps request bodies; attachment uploads are bounded by
+	// Attachment.MaxSize instead.
+	MaxBodySize int64
+	// TrustedProxies lists the IPs or CIDRs whose X-Forwarded-For header is
+	// honoured by ClientIP. Empty means no proxy is trusted.
+	TrustedProxies []string
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string
+	MaxAge         time.Duration
+}
+
+type AuthConfig struct {
+	AccessTTL      time.Duration
+	RefreshTTL     time.Duration
+	MaxFailedLogin int
+	LockDuration   time.Duration
+	Issuer         string
+	// Audience is the "aud" of access tokens; services verifying them
+	// through the JWKS should check it.
+	Audience string
+	// Leeway is the clock skew tolerated when checking token times.
+	Leeway time.Duration
+	// RevocationCacheTTL is how long other replicas may keep honouring a
+	// revoked access token.
+	RevocationCacheTTL time.Duration
+}
+
+type WSConfig struct {
+	// Backplane is "memory" for a single replica or "postgres" to share
+	// WebSocket broadcasts between replicas through LISTEN/NOTIFY.
+	Backplane string
+	// TicketTTL is how long a ticket from POST /api/ws/ticket stays valid.
+	TicketTTL time.Duration
+
+	AllowedOrigins  []string
+	MaxMessageSize  int64
+	MaxConnsPerUser int
+	MaxConnsPerIP   int
+	// FrameRate is the sustained number of inbound frames per second a
+	// socket may send; FrameBurst is the bucket size.
+	FrameRate  int
+	FrameBurst int
+}
+
+type ChatConfig struct {
+	EditWindow time.Duration
+}
+
+type AttachmentConfig struct {
+	MaxSize int64
+	// AllowedTypes holds MIME types; "image/*" style wildcards are accepted.
+	AllowedTypes []string
+	// PendingTTL is how long an upload is kept if it is never sent.
+	PendingTTL time.Duration
+}
+
+type StorageConfig struct {
+	// Driver is either "local" or "s3".
+	Driver   string
+	LocalDir string
+
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
 }
 
 type JWTConfig struct {
+	// Secret seals the signing keys stored in the database.
 	Secret string
+	// Algorithm is "EdDSA" or "RS256".
+	Algorithm string
+	// KeyRotation is how long a signing key is used before it is replaced.
+	KeyRotation time.Duration
+	// KeyPrepublish is how long a new key is listed in the JWKS before it
+	// signs; KeyGrace is how long a replaced key is still accepted.
+	KeyPrepublish time.Duration
+	KeyGrace      time.Duration
+	// KeyRefresh is how often replicas reload the keys.
+	KeyRefresh time.Duration
 }
 
 type AppConfig struct {
@@ -31,46 +132,224 @@ type DBConfig struct {
 	Name     string
 	SSLMode  string
 }
+
+// DSN builds the connection URL for the database.
+func (c DBConfig) DSN() string {
+	return fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
+		c.User,
+		c.Password,
+		c.Host,
+		c.Port,
+		c.Name,
+		c.SSLMode,
+	)
+}
+
 type Migration struct {
 	Valided bool
 }
 
-func Load() *Config {
+var defaultOrigins = []string{"http://localhost:3000", "http://127.0.0.1:3000"}
+
+// Load reads the configuration from the environment, falling back to the file
+// named by CONFIG_FILE (YAML or TOML) and then to built-in defaults. It fails
+// if a value cannot be parsed or the result does not pass validation.
+func Load() (*Config, error) {
 	if err := godotenv.Load(); err != nil {
 		log.Println("no .env file, using system env")
 	}
 
+	l := &loader{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+		l.file = values
+	}
+
 	cfg := &Config{
 		App: AppConfig{
-			Env:  getEnv("APP_ENV", "development"),
-			Port: getEnv("APP_PORT", "8080"),
+			Env:  l.getEnv("APP_ENV", "development"),
+			Port: l.getEnv("APP_PORT", "8080"),
+		},
+		HTTP: HTTPConfig{
+			ReadTimeout:       l.getDuration("HTTP_READ_TIMEOUT", 60*time.Second),
+			ReadHeaderTimeout: l.getDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+			WriteTimeout:      l.getDuration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+			IdleTimeout:       l.getDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+			ShutdownTimeout:   l.getDuration("HTTP_SHUTDOWN_TIMEOUT", 30*time.Second),
+			MaxBodySize:       l.getInt64("HTTP_MAX_BODY_SIZE", 1<<20),
+			TrustedProxies:    l.getList("HTTP_TRUSTED_PROXIES", nil),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: l.getList("CORS_ALLOWED_ORIGINS", defaultOrigins),
+			MaxAge:         l.getDuration("CORS_MAX_AGE", 12*time.Hour),
 		},
 		DB: DBConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnv("DB_PORT", "5432"),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: os.Getenv("DB_PASSWORD"),
-			Name:     getEnv("DB_NAME", "postgres"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+			Host:     l.getEnv("DB_HOST", "localhost"),
+			Port:     l.getEnv("DB_PORT", "5432"),
+			User:     l.getEnv("DB_USER", "postgres"),
+			Password: l.getEnv("DB_PASSWORD", ""),
+			Name:     l.getEnv("DB_NAME", "postgres"),
+			SSLMode:  l.getEnv("DB_SSLMODE", "disable"),
 		},
 		Migration: Migration{
-			Valided: getEnv("MIGRATION", "true") == "true",
+			Valided: l.getBool("MIGRATION", true),
 		},
 		JWT: JWTConfig{
-			Secret: os.Getenv("JWT_SECRET"),
+			Secret:    l.getEnv("JWT_SECRET", ""),
+			Algorithm: l.getEnv("JWT_ALGORITHM", "EdDSA"),
+
+			KeyRotation:   l.getDuration("JWT_KEY_ROTATION", 30*24*time.Hour),
+			KeyPrepublish: l.getDuration("JWT_KEY_PREPUBLISH", time.Hour),
+			KeyGrace:      l.getDuration("JWT_KEY_GRACE", 24*time.Hour),
+			KeyRefresh:    l.getDuration("JWT_KEY_REFRESH", time.Minute),
+		},
+		Auth: AuthConfig{
+			AccessTTL:      l.getDuration("AUTH_ACCESS_TTL", 15*time.Minute),
+			RefreshTTL:     l.getDuration("AUTH_REFRESH_TTL", 30*24*time.Hour),
+			MaxFailedLogin: l.getInt("AUTH_MAX_FAILED_LOGIN", 5),
+			LockDuration:   l.getDuration("AUTH_LOCK_DURATION", 15*time.Minute),
+			Issuer:         l.getEnv("AUTH_ISSUER", "talk-backend"),
+			Audience:       l.getEnv("AUTH_AUDIENCE", "talk-api"),
+			Leeway:         l.getDuration("AUTH_LEEWAY", 30*time.Second),
+
+			RevocationCacheTTL: l.getDuration("AUTH_REVOCATION_CACHE_TTL", 5*time.Second),
+		},
+		Chat: ChatConfig{
+			EditWindow: l.getDuration("MESSAGE_EDIT_WINDOW", 15*time.Minute),
+		},
+		Attachment: AttachmentConfig{
+			MaxSize: l.getInt64("ATTACHMENT_MAX_SIZE", 10<<20),
+			AllowedTypes: l.getList("ATTACHMENT_ALLOWED_TYPES", []string{
+				"image/*",
+				"video/mp4",
+				"audio/mpeg",
+				"application/pdf",
+				"application/zip",
+				"text/plain",
+			}),
+			PendingTTL: l.getDuration("ATTACHMENT_PENDING_TTL", 24*time.Hour),
+		},
+		Storage: StorageConfig{
+			Driver:   l.getEnv("STORAGE_DRIVER", "local"),
+			LocalDir: l.getEnv("STORAGE_LOCAL_DIR", "./uploads"),
+
+			S3Endpoint:  l.getEnv("S3_ENDPOINT", ""),
+			S3Region:    l.getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:    l.getEnv("S3_BUCKET", ""),
+			S3AccessKey: l.getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey: l.getEnv("S3_SECRET_KEY", ""),
+		},
+		WS: WSConfig{
+			Backplane: l.getEnv("WS_BACKPLANE", "memory"),
+			TicketTTL: l.getDuration("WS_TICKET_TTL", 30*time.Second),
+
+			AllowedOrigins:  l.getList("WS_ALLOWED_ORIGINS", defaultOrigins),
+			MaxMessageSize:  l.getInt64("WS_MAX_MESSAGE_SIZE", 32<<10),
+			MaxConnsPerUser: l.getInt("WS_MAX_CONNS_PER_USER", 10),
+			MaxConnsPerIP:   l.getInt("WS_MAX_CONNS_PER_IP", 50),
+			FrameRate:       l.getInt("WS_FRAME_RATE", 20),
+			FrameBurst:      l.getInt("WS_FRAME_BURST", 40),
 		},
 	}
 
-	cfg.validate()
-	return cfg
+	if err := errors.Join(append(l.errs, cfg.validate())...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loader resolves keys from the environment first and the config file second,
+// and collects parse errors so they are all reported at once.
+type loader struct {
+	file map[string]string
+	errs []error
 }
 
-func getEnv(key, fallback string) string {
+func (l *loader) lookup(key string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
 	}
+	return l.file[key]
+}
+
+func (l *loader) invalid(key, val, kind string) {
+	l.errs = append(l.errs, fmt.Errorf("%s: %q is not a valid %s", key, val, kind))
+}
+
+func (l *loader) getEnv(key, fallback string) string {
+	if val := l.lookup(key); val != "" {
+		return val
+	}
 	return fallback
 }
 
-func (c *Config) validate() {
+func (l *loader) getDuration(key string, fallback time.Duration) time.Duration {
+	val := l.lookup(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		l.invalid(key, val, "duration")
+		return fallback
+	}
+	return d
+}
+
+func (l *loader) getInt64(key string, fallback int64) int64 {
+	val := l.lookup(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		l.invalid(key, val, "integer")
+		return fallback
+	}
+	return n
+}
+
+func (l *loader) getInt(key string, fallback int) int {
+	val := l.lookup(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		l.invalid(key, val, "integer")
+		return fallback
+	}
+	return n
+}
+
+func (l *loader) getBool(key string, fallback bool) bool {
+	val := l.lookup(key)
+	if val == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		l.invalid(key, val, "boolean")
+		return fallback
+	}
+	return b
+}
+
+// getList reads a comma-separated list.
+func (l *loader) getList(key string, fallback []string) []string {
+	val := l.lookup(key)
+	if val == "" {
+		return fallback
+	}
+	var out []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
 }
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// validate rejects configurations the server cannot run safely with. All
+// problems are reported together so a bad deploy needs only one fix round.
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.JWT.Secret != "", "JWT_SECRET is required")
+	check(c.App.Env != "production" || len(c.JWT.Secret) >= 32, "JWT_SECRET must be at least 32 bytes in production")
+	check(c.JWT.Algorithm == "EdDSA" || c.JWT.Algorithm == "RS256", "JWT_ALGORITHM: %q must be EdDSA or RS256", c.JWT.Algorithm)
+	check(c.JWT.KeyRefresh > 0, "JWT_KEY_REFRESH must be positive")
+	check(c.JWT.KeyPrepublish > c.JWT.KeyRefresh, "JWT_KEY_PREPUBLISH must be longer than JWT_KEY_REFRESH")
+	check(c.JWT.KeyRotation > c.JWT.KeyPrepublish, "JWT_KEY_ROTATION must be longer than JWT_KEY_PREPUBLISH")
+	check(c.JWT.KeyGrace >= c.Auth.AccessTTL, "JWT_KEY_GRACE must not be shorter than AUTH_ACCESS_TTL")
+	check(validPort(c.App.Port), "APP_PORT: %q is not a valid port", c.App.Port)
+	check(validPort(c.DB.Port), "DB_PORT: %q is not a valid port", c.DB.Port)
+	check(c.DB.Host != "", "DB_HOST is required")
+	check(c.DB.Name != "", "DB_NAME is required")
+
+	check(c.HTTP.ReadTimeout >= 0, "HTTP_READ_TIMEOUT must not be negative")
+	check(c.HTTP.ReadHeaderTimeout >= 0, "HTTP_READ_HEADER_TIMEOUT must not be negative")
+	check(c.HTTP.WriteTimeout >= 0, "HTTP_WRITE_TIMEOUT must not be negative")
+	check(c.HTTP.IdleTimeout >= 0, "HTTP_IDLE_TIMEOUT must not be negative")
+	check(c.HTTP.ShutdownTimeout > 0, "HTTP_SHUTDOWN_TIMEOUT must be positive")
+	check(c.HTTP.MaxBodySize > 0, "HTTP_MAX_BODY_SIZE must be positive")
+	for _, p := range c.HTTP.TrustedProxies {
+		check(validProxy(p), "HTTP_TRUSTED_PROXIES: %q is not an IP or CIDR", p)
+	}
+
+	check(len(c.CORS.AllowedOrigins) > 0, "CORS_ALLOWED_ORIGINS must not be empty")
+	for _, o := range c.CORS.AllowedOrigins {
+		check(validOrigin(o), "CORS_ALLOWED_ORIGINS: %q must start with http:// or https://", o)
+	}
+	check(c.CORS.MaxAge >= 0, "CORS_MAX_AGE must not be negative")
+
+	check(c.Auth.AccessTTL > 0, "AUTH_ACCESS_TTL must be positive")
+	check(c.Auth.RefreshTTL > c.Auth.AccessTTL, "AUTH_REFRESH_TTL must be longer than AUTH_ACCESS_TTL")
+	check(c.Auth.MaxFailedLogin > 0, "AUTH_MAX_FAILED_LOGIN must be positive")
+	check(c.Auth.LockDuration > 0, "AUTH_LOCK_DURATION must be positive")
+	check(c.Auth.Issuer != "", "AUTH_ISSUER is required")
+	check(c.Auth.Audience != "", "AUTH_AUDIENCE is required")
+	check(c.Auth.Leeway >= 0 && c.Auth.Leeway < c.Auth.AccessTTL, "AUTH_LEEWAY must not be negative or reach AUTH_ACCESS_TTL")
+	check(c.Auth.RevocationCacheTTL >= 0, "AUTH_REVOCATION_CACHE_TTL must not be negative")
+
+	check(c.Chat.EditWindow >= 0, "MESSAGE_EDIT_WINDOW must not be negative")
+	check(c.Attachment.MaxSize > 0, "ATTACHMENT_MAX_SIZE must be positive")
+	check(len(c.Attachment.AllowedTypes) > 0, "ATTACHMENT_ALLOWED_TYPES must not be empty")
+	check(c.Attachment.PendingTTL > 0, "ATTACHMENT_PENDING_TTL must be positive")
+
+	switch c.Storage.Driver {
+	case "local":
+		check(c.Storage.LocalDir != "", "STORAGE_LOCAL_DIR is required for the local driver")
+	case "s3":
+		check(c.Storage.S3Bucket != "", "S3_BUCKET is required for the s3 driver")
+	default:
+		check(false, "STORAGE_DRIVER: %q must be local or s3", c.Storage.Driver)
+	}
+
+	check(c.WS.Backplane == "memory" || c.WS.Backplane == "postgres", "WS_BACKPLANE: %q must be memory or postgres", c.WS.Backplane)
+	check(c.WS.TicketTTL > 0, "WS_TICKET_TTL must be positive")
+	for _, o := range c.WS.AllowedOrigins {
+		check(o == "*" || validOrigin(o), "WS_ALLOWED_ORIGINS: %q must be * or start with http:// or https://", o)
+	}
+	check(c.WS.MaxMessageSize > 0, "WS_MAX_MESSAGE_SIZE must be positive")
+	check(c.WS.MaxConnsPerUser >= 0, "WS_MAX_CONNS_PER_USER must not be negative")
+	check(c.WS.MaxConnsPerIP >= 0, "WS_MAX_CONNS_PER_IP must not be negative")
+	check(c.WS.FrameRate > 0, "WS_FRAME_RATE must be positive")
+	check(c.WS.FrameBurst > 0, "WS_FRAME_BURST must be positive")
+
+	return errors.Join(errs...)
+}
+
+func validPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n <= 65535
+}
+
+func validProxy(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+func validOrigin(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
@@ -1,61 +1,220 @@
 package container
 
 import (
-	"time"
+	"context"
+	"errors"
+	"fmt"
 
+	"talk-backend/internal/auth"
 	"talk-backend/internal/config"
 	"talk-backend/internal/http/controllers"
 	"talk-backend/internal/repository"
 	"talk-backend/internal/service"
+	"talk-backend/internal/storage"
 	"talk-backend/internal/ws"
 
 	"gorm.io/gorm"
 )
 
 type App struct {
-	AuthController *controllers.AuthController
-	ChatController *controllers.ChatController
-	UserController *controllers.UserController
-	WSHandler      *ws.WSHandler
+	AuthController       *controllers.AuthController
+	ChatController       *controllers.ChatController
+	AttachmentController *controllers.AttachmentController
+	UserController       *controllers.UserController
+	TicketController     *controllers.TicketController
+	SessionController    *controllers.SessionController
+	JWKSController       *controllers.JWKSController
+	Verifier             *auth.Verifier
+	TokenGuard           *service.TokenGuard
+	WSHandler            *ws.WSHandler
+	SSEHandler           *ws.SSEHandler
+
+	keys        *service.KeyService
+	hub         *ws.Hub
+	presence    *service.PresenceService
+	broadcaster ws.Broadcaster
 }
 
-func New(cfg *config.Config, db *gorm.DB) *App {
+func New(cfg *config.Config, db *gorm.DB) (*App, error) {
 	userRepo := repository.NewUserRepository(db)
 	rtRepo := repository.NewRefreshTokenRepository(db)
 	auditRepo := repository.NewAuditRepository(db)
 	convRepo := repository.NewConversationRepository(db)
 	msgRepo := repository.NewMessageRepository(db)
+	attRepo := repository.NewAttachmentRepository(db)
+	ticketRepo := repository.NewWSTicketRepository(db)
+	keyRepo := repository.NewSigningKeyRepository(db)
+
+	store, err := newStorage(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	keyService, err := service.NewKeyService(keyRepo, service.KeyConfig{
+		Algorithm:  cfg.JWT.Algorithm,
+		Secret:     cfg.JWT.Secret,
+		Rotation:   cfg.JWT.KeyRotation,
+		Prepublish: cfg.JWT.KeyPrepublish,
+		Grace:      cfg.JWT.KeyGrace,
+		Refresh:    cfg.JWT.KeyRefresh,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go keyService.Run()
+	verifier := auth.NewVerifier(keyService.Keyfunc, auth.Config{
+		Issuer:   cfg.Auth.Issuer,
+		Audience: cfg.Auth.Audience,
+		Leeway:   cfg.Auth.Leeway,
+	})
 
+	presenceService := service.NewPresenceService(userRepo, convRepo)
+
+	broadcaster, err := newBroadcaster(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	hub := ws.NewHub(broadcaster)
+	hub.SetPresence(presenceService)
+	go hub.Run()
+	go presenceService.Run(hub)
+
+	tokenGuard := service.NewTokenGuard(
+		userRepo,
+		rtRepo,
+		service.TokenGuardConfig{
+			CacheTTL: cfg.Auth.RevocationCacheTTL,
+		},
+	)
+	sessionService := service.NewSessionService(userRepo, rtRepo, auditRepo, tokenGuard, hub)
 	authService := service.NewAuthService(
 		userRepo,
 		rtRepo,
 		auditRepo,
+		sessionService,
+		keyService,
 		service.AuthConfig{
-			JWTSecret:      cfg.JWT.Secret,
-			AccessTTL:      15 * time.Minute,
-			RefreshTTL:     30 * 24 * time.Hour,
-			MaxFailedLogin: 5,
-			LockDuration:   15 * time.Minute,
-			Issuer:         "talk-backend",
+			AccessTTL:      cfg.Auth.AccessTTL,
+			RefreshTTL:     cfg.Auth.RefreshTTL,
+			MaxFailedLogin: cfg.Auth.MaxFailedLogin,
+			LockDuration:   cfg.Auth.LockDuration,
+			Issuer:         cfg.Auth.Issuer,
+			Audience:       cfg.Auth.Audience,
 		},
 	)
 
-	chatService := service.NewChatService(db, convRepo, msgRepo)
+	chatService := service.NewChatService(
+		db,
+		userRepo,
+		convRepo,
+		msgRepo,
+		attRepo,
+		store,
+		hub,
+		service.ChatConfig{
+			EditWindow: cfg.Chat.EditWindow,
+		},
+	)
+	attachmentService := service.NewAttachmentService(
+		convRepo,
+		attRepo,
+		store,
+		service.AttachmentConfig{
+			MaxSize:      cfg.Attachment.MaxSize,
+			AllowedTypes: cfg.Attachment.AllowedTypes,
+			PendingTTL:   cfg.Attachment.PendingTTL,
+		},
+	)
 	userService := service.NewUserService(userRepo)
+	ticketService := service.NewTicketService(
+		ticketRepo,
+		convRepo,
+		service.TicketConfig{
+			TTL: cfg.WS.TicketTTL,
+		},
+	)
 
 	authCtl := controllers.NewAuthController(authService)
 	chatCtl := controllers.NewChatController(chatService)
-	userCtl := controllers.NewUserController(userService)
+	attachmentCtl := controllers.NewAttachmentController(attachmentService, cfg.Attachment.MaxSize)
+	userCtl := controllers.NewUserController(userService, presenceService)
+	ticketCtl := controllers.NewTicketController(ticketService)
+	sessionCtl := controllers.NewSessionController(sessionService)
+	jwksCtl := controllers.NewJWKSController(keyService)
 
-	hub := ws.NewHub()
-	go hub.Run()
-
-	wsHandler := ws.NewWSHandler(hub, chatService, cfg.JWT.Secret)
+	wsHandler := ws.NewWSHandler(hub, chatService, presenceService, ticketService, tokenGuard, verifier, ws.Config{
+		AllowedOrigins:  cfg.WS.AllowedOrigins,
+		MaxMessageSize:  cfg.WS.MaxMessageSize,
+		MaxConnsPerUser: cfg.WS.MaxConnsPerUser,
+		MaxConnsPerIP:   cfg.WS.MaxConnsPerIP,
+		FrameRate:       float64(cfg.WS.FrameRate),
+		FrameBurst:      cfg.WS.FrameBurst,
+	})
+	sseHandler := ws.NewSSEHandler(hub, chatService)
 
 	return &App{
-		AuthController: authCtl,
-		ChatController: chatCtl,
-		UserController: userCtl,
-		WSHandler:      wsHandler,
+		AuthController:       authCtl,
+		ChatController:       chatCtl,
+		AttachmentController: attachmentCtl,
+		UserController:       userCtl,
+		TicketController:     ticketCtl,
+		SessionController:    sessionCtl,
+		JWKSController:       jwksCtl,
+		Verifier:             verifier,
+		TokenGuard:           tokenGuard,
+		WSHandler:            wsHandler,
+		SSEHandler:           sseHandler,
+
+		keys:        keyService,
+		hub:         hub,
+		presence:    presenceService,
+		broadcaster: broadcaster,
+	}, nil
+}
+
+// GoAway tells realtime clients to reconnect elsewhere. It is meant for
+// http.Server.RegisterOnShutdown, so SSE streams end and Shutdown can finish.
+func (a *App) GoAway() {
+	a.hub.GoAway()
+}
+
+// Shutdown waits for realtime connections to drain, flushes presence updates,
+// stops key rotation and closes the backplane. Call it after the HTTP server has stopped and
+// before the database is closed.
+func (a *App) Shutdown(ctx context.Context) error {
+	return errors.Join(
+		a.hub.Shutdown(ctx),
+		a.presence.Stop(ctx),
+		a.keys.Stop(ctx),
+		a.broadcaster.Close(),
+	)
+}
+
+func newStorage(cfg config.StorageConfig) (storage.Storage, error) {
+	switch cfg.Driver {
+	case "local":
+		return storage.NewLocalStorage(cfg.LocalDir)
+	case "s3":
+		return storage.NewS3Storage(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+func newBroadcaster(cfg *config.Config, db *gorm.DB) (ws.Broadcaster, error) {
+	switch cfg.WS.Backplane {
+	case "memory":
+		return ws.NewMemoryBroadcaster(), nil
+	case "postgres":
+		return ws.NewPostgresBroadcaster(db, cfg.DB.DSN())
+	default:
+		return nil, fmt.Errorf("unknown websocket backplane %q", cfg.WS.Backplane)
 	}
 }
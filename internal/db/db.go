@@ -10,17 +10,7 @@ import (
 )
 
 func ConnectPostgres(cfg *config.Config) (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
-		cfg.DB.User,
-		cfg.DB.Password,
-		cfg.DB.Host,
-		cfg.DB.Port,
-		cfg.DB.Name,
-		cfg.DB.SSLMode,
-	)
-
-	gdb, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	gdb, err := gorm.Open(postgres.Open(cfg.DB.DSN()), &gorm.Config{})
 	if err != nil {
 		return nil, fmt.Errorf("connect postgres: %w", err)
 	}
@@ -7,5 +7,48 @@ import (
 )
 
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(&models.User{}, &models.RefreshToken{}, &models.AuditLog{}, &models.Conversation{}, &models.ConversationMember{}, &models.Message{})
+	if err := dedupeMembers(db); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.RefreshToken{}, &models.AuditLog{}, &models.Conversation{}, &models.ConversationMember{}, &models.Message{}, &models.MessageEdit{}, &models.HiddenMessage{}, &models.MessageReaction{}, &models.Attachment{}, &models.WSTicket{}, &models.SigningKey{}); err != nil {
+		return err
+	}
+	if err := migrateTokenFamilies(db); err != nil {
+		return err
+	}
+	return migrateSearch(db)
+}
+
+// dedupeMembers drops duplicate memberships, keeping the oldest, so the
+// unique index on (conversation_id, user_id) can be created.
+func dedupeMembers(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.ConversationMember{}) {
+		return nil
+	}
+	return db.Exec(`DELETE FROM conversation_members a USING conversation_members b
+		WHERE a.conversation_id = b.conversation_id AND a.user_id = b.user_id AND a.id > b.id`).Error
+}
+
+// migrateTokenFamilies gives refresh tokens issued before rotation families
+// existed a family of their own, so they show up as sessions.
+func migrateTokenFamilies(db *gorm.DB) error {
+	return db.Exec(`UPDATE refresh_tokens SET family_id = 'legacy-' || id
+		WHERE family_id IS NULL OR family_id = ''`).Error
+}
+
+// migrateSearch adds the full-text search column on messages. It is generated
+// by PostgreSQL from content, so edits and deletions keep it in sync. The
+// "simple" configuration skips stemming, which suits multilingual chats.
+func migrateSearch(db *gorm.DB) error {
+	stmts := []string{
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector)`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"talk-backend/internal/http/dto"
+	"talk-backend/internal/http/middleware"
+	"talk-backend/internal/http/response"
+	"talk-backend/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead leaves room for boundaries and form fields around the file.
+const multipartOverhead = 1 << 20
+
+type AttachmentController struct {
+	attachments *service.AttachmentService
+	maxSize     int64
+}
+
+func NewAttachmentController(attachments *service.AttachmentService, maxSize int64) *AttachmentController {
+	return &AttachmentController{attachments: attachments, maxSize: maxSize}
+}
+
+// Upload godoc
+// @Summary Upload an attachment
+// @Description Upload a file to a conversation. Reference the returned ID in attachmentIds when sending a message.
+// @Tags attachments
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param file formData file true "File to upload"
+// @Success 201 {object} dto.AttachmentResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 413 {object} dto.ErrorResponse
+// @Failure 415 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/attachments [post]
+func (ctl *AttachmentController) Upload(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, ctl.maxSize+multipartOverhead)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			response.Error(c, http.StatusRequestEntityTooLarge, response.CodeFileTooLarge, response.MsgFileTooLarge)
+			return
+		}
+		response.InvalidBody(c, err)
+		return
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+	defer f.Close()
+
+	att, err := ctl.attachments.Upload(c.Request.Context(), me, convID, fh.Filename, f, fh.Size)
+	if err != nil {
+		chatError(c, err, response.CodeAttachmentFailed, response.MsgUploadAttachment)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.AttachmentResponse{Attachment: *att})
+}
+
+// Download godoc
+// @Summary Download an attachment
+// @Description Stream an attachment. Only members of its conversation may download it.
+// @Tags attachments
+// @Security BearerAuth
+// @Produce octet-stream
+// @Param attachmentId path int true "Attachment ID"
+// @Success 200 {file} file
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/attachments/{attachmentId} [get]
+func (ctl *AttachmentController) Download(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	id64, err := strconv.ParseUint(c.Param("attachmentId"), 10, 64)
+	if err != nil || id64 == 0 {
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidAttachment)
+		return
+	}
+
+	att, rc, err := ctl.attachments.Open(c.Request.Context(), me, uint(id64))
+	if err != nil {
+		chatError(c, err, response.CodeAttachmentFailed, response.MsgDownloadAttachment)
+		return
+	}
+	defer rc.Close()
+
+	c.DataFromReader(http.StatusOK, att.Size, att.MimeType, rc, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": att.FileName}),
+		"X-Content-Type-Options": "nosniff",
+		"ETag":                   `"` + att.Checksum + `"`,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"talk-backend/internal/http/dto"
+	"talk-backend/internal/http/middleware"
 	"talk-backend/internal/http/response"
 	"talk-backend/internal/repository"
 	"talk-backend/internal/service"
@@ -82,7 +83,7 @@ func (ctl *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	access, refresh, err := ctl.auth.Login(req.Email, req.Password, clientIP(c), userAgent(c))
+	access, refresh, err := ctl.auth.Login(req.Email, req.Password, req.DeviceName, clientIP(c), userAgent(c))
 	if err != nil {
 		response.Error(c, http.StatusUnauthorized, response.CodeInvalidCredentials, response.MsgInvalidCredentials)
 		return
@@ -145,3 +146,28 @@ func (ctl *AuthController) Logout(c *gin.Context) {
 	_ = ctl.auth.Logout(req.RefreshToken, clientIP(c), userAgent(c))
 	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
 }
+
+// LogoutAll godoc
+// @Summary Logout everywhere
+// @Description Revoke every access and refresh token of the caller and close their realtime connections.
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.MessageResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /auth/logout-all [post]
+func (ctl *AuthController) LogoutAll(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	if err := ctl.auth.LogoutAll(me, clientIP(c), userAgent(c)); err != nil {
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgLogoutAll)
+		return
+	}
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
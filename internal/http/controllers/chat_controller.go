@@ -1,13 +1,16 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"talk-backend/internal/http/dto"
 	"talk-backend/internal/http/middleware"
 	"talk-backend/internal/http/response"
+	"talk-backend/internal/repository"
 	"talk-backend/internal/service"
+	"talk-backend/internal/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -34,11 +37,12 @@ func NewChatController(chat *service.ChatService) *ChatController {
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/conversations/direct [post]
 func (ctl *ChatController) CreateDirect(c *gin.Context) {
-	me, ok := middleware.GetUserID(c)
+	principal, ok := middleware.GetPrincipal(c)
 	if !ok {
 		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
 		return
 	}
+	me := principal.UserID
 
 	var req dto.DirectConversationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -55,9 +59,340 @@ func (ctl *ChatController) CreateDirect(c *gin.Context) {
 	c.JSON(http.StatusCreated, dto.ConversationResponse{Conversation: *conv})
 }
 
+// CreateGroup godoc
+// @Summary Create a group conversation
+// @Description Create a titled group conversation. The caller becomes its owner. Unknown member IDs are rejected with 400.
+// @Tags conversations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.GroupConversationRequest true "Group conversation payload"
+// @Success 201 {object} dto.ConversationResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/group [post]
+func (ctl *ChatController) CreateGroup(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	var req dto.GroupConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	conv, err := ctl.chat.CreateGroupConversation(me, req.Title, req.MemberIDs)
+	if err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgCreateConversation)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.ConversationResponse{Conversation: *conv})
+}
+
+// UpdateMemberRole godoc
+// @Summary Change a member's role
+// @Description Promote a group member to admin or demote an admin. Owner only.
+// @Tags conversations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param userId path string true "Member user ID"
+// @Param request body dto.UpdateMemberRoleRequest true "Role payload"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/members/{userId}/role [put]
+func (ctl *ChatController) UpdateMemberRole(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var uri dto.MemberURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidUserID)
+		return
+	}
+
+	var req dto.UpdateMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	if err := ctl.chat.SetMemberRole(me, convID, uri.UserID, req.Role); err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgUpdateMember)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
+// TransferOwnership godoc
+// @Summary Transfer group ownership
+// @Description Make another member the owner of the group. The caller becomes an admin.
+// @Tags conversations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param request body dto.TransferOwnershipRequest true "New owner payload"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/owner [post]
+func (ctl *ChatController) TransferOwnership(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req dto.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	if err := ctl.chat.TransferOwnership(me, convID, req.UserID); err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgUpdateMember)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
+// ListMembers godoc
+// @Summary List conversation members
+// @Description Return the members of a conversation with their roles.
+// @Tags conversations
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Success 200 {object} dto.MembersResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/members [get]
+func (ctl *ChatController) ListMembers(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	members, err := ctl.chat.ListMembers(me, convID)
+	if err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgListMembers)
+		return
+	}
+
+	out := make([]dto.MemberPublic, 0, len(members))
+	for _, m := range members {
+		out = append(out, memberPublic(m))
+	}
+
+	c.JSON(http.StatusOK, dto.MembersResponse{Members: out})
+}
+
+// AddMembers godoc
+// @Summary Add members to a group
+// @Description Add users to a group conversation. Owners and admins only. Unknown user IDs are rejected with 400 and nobody is added.
+// @Tags conversations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param request body dto.AddMembersRequest true "Members payload"
+// @Success 200 {object} dto.AddMembersResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/members [post]
+func (ctl *ChatController) AddMembers(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req dto.AddMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	added, err := ctl.chat.AddMembers(me, convID, req.UserIDs)
+	if err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgAddMembers)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AddMembersResponse{Added: added})
+}
+
+// RemoveMember godoc
+// @Summary Remove a member from a group
+// @Description Remove a member from a group conversation. Admins can remove members, the owner can also remove admins.
+// @Tags conversations
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param userId path string true "Member user ID"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/members/{userId} [delete]
+func (ctl *ChatController) RemoveMember(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var uri dto.MemberURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidUserID)
+		return
+	}
+
+	if err := ctl.chat.RemoveMember(me, convID, uri.UserID); err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgRemoveMember)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
+// Leave godoc
+// @Summary Leave a group
+// @Description Leave a group conversation. If the owner leaves, ownership passes to the oldest admin or member.
+// @Tags conversations
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/leave [post]
+func (ctl *ChatController) Leave(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := ctl.chat.LeaveConversation(me, convID); err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgLeaveConversation)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
+// MarkRead godoc
+// @Summary Mark a conversation as read
+// @Description Move the caller's read cursor up to the given message. The cursor never moves backwards.
+// @Tags conversations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param request body dto.MarkReadRequest true "Read cursor payload"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/read [post]
+func (ctl *ChatController) MarkRead(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req dto.MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	if err := ctl.chat.MarkRead(me, convID, req.MessageID); err != nil {
+		chatError(c, err, response.CodeConversationFailed, response.MsgMarkRead)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
 // ListMyConversations godoc
 // @Summary List my conversations
-// @Description Return conversations the authenticated user is a member of.
+// @Description Return conversations the authenticated user is a member of, most recently active first, with the last message, unread count and members.
 // @Tags conversations
 // @Security BearerAuth
 // @Produce json
@@ -66,11 +401,12 @@ func (ctl *ChatController) CreateDirect(c *gin.Context) {
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/conversations [get]
 func (ctl *ChatController) ListMyConversations(c *gin.Context) {
-	me, ok := middleware.GetUserID(c)
+	principal, ok := middleware.GetPrincipal(c)
 	if !ok {
 		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
 		return
 	}
+	me := principal.UserID
 
 	convs, err := ctl.chat.ListMyConversations(me)
 	if err != nil {
@@ -78,12 +414,17 @@ func (ctl *ChatController) ListMyConversations(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.ConversationsResponse{Conversations: convs})
+	out := make([]dto.ConversationSummary, 0, len(convs))
+	for _, conv := range convs {
+		out = append(out, conversationSummary(conv, me))
+	}
+
+	c.JSON(http.StatusOK, dto.ConversationsResponse{Conversations: out})
 }
 
 // SendMessage godoc
 // @Summary Send a message
-// @Description Send a message in a conversation.
+// @Description Send a message in a conversation, optionally quoting another message (replyTo), posting in a thread (threadRoot) or attaching uploaded files (attachmentIds). A retry carrying the same clientMessageId returns the message stored the first time.
 // @Tags messages
 // @Security BearerAuth
 // @Accept json
@@ -94,14 +435,16 @@ func (ctl *ChatController) ListMyConversations(c *gin.Context) {
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 401 {object} dto.ErrorResponse
 // @Failure 403 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/conversations/{id}/messages [post]
 func (ctl *ChatController) SendMessage(c *gin.Context) {
-	me, ok := middleware.GetUserID(c)
+	principal, ok := middleware.GetPrincipal(c)
 	if !ok {
 		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
 		return
 	}
+	me := principal.UserID
 
 	convID64, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil || convID64 == 0 {
@@ -116,13 +459,16 @@ func (ctl *ChatController) SendMessage(c *gin.Context) {
 		return
 	}
 
-	msg, err := ctl.chat.SendMessage(me, convID, req.Content)
+	msg, err := ctl.chat.SendMessage(me, convID, service.NewMessage{
+		Content:       req.Content,
+		ReplyToID:     req.ReplyTo,
+		ThreadRootID:  req.ThreadRoot,
+		AttachmentIDs: req.AttachmentIDs,
+
+		ClientMessageID: req.ClientMessageID,
+	})
 	if err != nil {
-		if err == service.ErrForbidden {
-			response.Error(c, http.StatusForbidden, response.CodeForbidden, response.MsgForbidden)
-			return
-		}
-		response.Error(c, http.StatusInternalServerError, response.CodeMessageFailed, response.MsgSendMessage)
+		chatError(c, err, response.CodeMessageFailed, response.MsgSendMessage)
 		return
 	}
 
@@ -131,7 +477,7 @@ func (ctl *ChatController) SendMessage(c *gin.Context) {
 
 // GetMessages godoc
 // @Summary Get messages
-// @Description Get messages in a conversation.
+// @Description Get the main timeline of a conversation. Thread replies are returned by the thread endpoint.
 // @Tags messages
 // @Security BearerAuth
 // @Produce json
@@ -145,11 +491,12 @@ func (ctl *ChatController) SendMessage(c *gin.Context) {
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/conversations/{id}/messages [get]
 func (ctl *ChatController) GetMessages(c *gin.Context) {
-	me, ok := middleware.GetUserID(c)
+	principal, ok := middleware.GetPrincipal(c)
 	if !ok {
 		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
 		return
 	}
+	me := principal.UserID
 
 	convID64, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil || convID64 == 0 {
@@ -158,15 +505,7 @@ func (ctl *ChatController) GetMessages(c *gin.Context) {
 	}
 	convID := uint(convID64)
 
-	limit, _ := strconv.Atoi(c.Query("limit"))
-	var beforeID *uint
-	if v := c.Query("beforeId"); v != "" {
-		b, err := strconv.ParseUint(v, 10, 64)
-		if err == nil && b > 0 {
-			tmp := uint(b)
-			beforeID = &tmp
-		}
-	}
+	limit, beforeID := pageParams(c)
 
 	msgs, err := ctl.chat.GetMessages(me, convID, limit, beforeID)
 	if err != nil {
@@ -180,3 +519,427 @@ func (ctl *ChatController) GetMessages(c *gin.Context) {
 
 	c.JSON(http.StatusOK, dto.MessagesResponse{Messages: msgs})
 }
+
+// SearchMessages godoc
+// @Summary Search messages
+// @Description Full-text search across the caller's conversations. q accepts quoted phrases, OR and -exclusions. Snippets are HTML-escaped message text with matches wrapped in <mark> tags, safe to render as HTML.
+// @Tags search
+// @Security BearerAuth
+// @Produce json
+// @Param q query string true "Search query"
+// @Param conversationId query int false "Restrict to one conversation"
+// @Param senderId query string false "Restrict to one sender"
+// @Param from query string false "Sent at or after (RFC 3339)"
+// @Param to query string false "Sent before (RFC 3339)"
+// @Param limit query int false "Max results to return"
+// @Param beforeId query int false "Return results before this message ID"
+// @Success 200 {object} dto.SearchResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/search/messages [get]
+func (ctl *ChatController) SearchMessages(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	var q dto.SearchMessagesQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	limit := q.Limit
+	if limit == 0 {
+		limit = 30
+	}
+	f := repository.SearchFilter{Query: q.Q, Limit: limit}
+	if q.ConversationID != 0 {
+		f.ConversationID = &q.ConversationID
+	}
+	if q.SenderID != "" {
+		f.SenderID = &q.SenderID
+	}
+	if !q.From.IsZero() {
+		f.From = &q.From
+	}
+	if !q.To.IsZero() {
+		f.To = &q.To
+	}
+	if q.BeforeID != 0 {
+		f.BeforeID = &q.BeforeID
+	}
+
+	hits, err := ctl.chat.SearchMessages(me, f)
+	if err != nil {
+		chatError(c, err, response.CodeMessageFailed, response.MsgSearchMessages)
+		return
+	}
+
+	out := dto.SearchResponse{Results: make([]dto.SearchResult, 0, len(hits))}
+	for _, h := range hits {
+		out.Results = append(out.Results, dto.SearchResult{
+			MessageID:      h.MessageID,
+			ConversationID: h.ConversationID,
+			SenderID:       h.SenderID,
+			SentAt:         h.SentAt,
+			Snippet:        h.Snippet,
+		})
+	}
+	if len(hits) == limit {
+		next := hits[len(hits)-1].MessageID
+		out.NextBeforeID = &next
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// GetThread godoc
+// @Summary Get a thread
+// @Description Get the root message of a thread and its replies, newest first.
+// @Tags messages
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param messageId path int true "Root message ID"
+// @Param limit query int false "Max replies to return"
+// @Param beforeId query int false "Return replies before this message ID"
+// @Success 200 {object} dto.ThreadResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/messages/{messageId}/thread [get]
+func (ctl *ChatController) GetThread(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+	rootID, ok := messageIDParam(c)
+	if !ok {
+		return
+	}
+
+	limit, beforeID := pageParams(c)
+
+	root, replies, err := ctl.chat.GetThread(me, convID, rootID, limit, beforeID)
+	if err != nil {
+		chatError(c, err, response.CodeMessageFailed, response.MsgGetThread)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ThreadResponse{Root: *root, Messages: replies})
+}
+
+// EditMessage godoc
+// @Summary Edit a message
+// @Description Replace the content of one of your own messages within the edit window. Previous versions are kept.
+// @Tags messages
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param messageId path int true "Message ID"
+// @Param request body dto.EditMessageRequest true "Message payload"
+// @Success 200 {object} dto.MessageResponseData
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/messages/{messageId} [patch]
+func (ctl *ChatController) EditMessage(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+	msgID, ok := messageIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req dto.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	msg, err := ctl.chat.EditMessage(me, convID, msgID, req.Content)
+	if err != nil {
+		chatError(c, err, response.CodeMessageFailed, response.MsgEditMessage)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponseData{Message: *msg})
+}
+
+// DeleteMessage godoc
+// @Summary Delete a message
+// @Description Hide a message for yourself (scope=me) or replace it with a tombstone for everyone (scope=everyone, sender or group admin only).
+// @Tags messages
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param messageId path int true "Message ID"
+// @Param scope query string false "me (default) or everyone"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/messages/{messageId} [delete]
+func (ctl *ChatController) DeleteMessage(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+	msgID, ok := messageIDParam(c)
+	if !ok {
+		return
+	}
+
+	var q dto.DeleteMessageQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidDeleteScope)
+		return
+	}
+	if q.Scope == "" {
+		q.Scope = service.DeleteForMe
+	}
+
+	if err := ctl.chat.DeleteMessage(me, convID, msgID, q.Scope); err != nil {
+		chatError(c, err, response.CodeMessageFailed, response.MsgDeleteMessage)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
+// AddReaction godoc
+// @Summary React to a message
+// @Description Add the caller's reaction with the given emoji. Adding the same reaction twice is a no-op.
+// @Tags messages
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param messageId path int true "Message ID"
+// @Param emoji path string true "Emoji (URL-encoded)"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/messages/{messageId}/reactions/{emoji} [put]
+func (ctl *ChatController) AddReaction(c *gin.Context) {
+	ctl.react(c, true)
+}
+
+// RemoveReaction godoc
+// @Summary Remove a reaction
+// @Description Remove the caller's reaction with the given emoji.
+// @Tags messages
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param messageId path int true "Message ID"
+// @Param emoji path string true "Emoji (URL-encoded)"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/conversations/{id}/messages/{messageId}/reactions/{emoji} [delete]
+func (ctl *ChatController) RemoveReaction(c *gin.Context) {
+	ctl.react(c, false)
+}
+
+func (ctl *ChatController) react(c *gin.Context, add bool) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	convID, ok := conversationIDParam(c)
+	if !ok {
+		return
+	}
+	msgID, ok := messageIDParam(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	if add {
+		err = ctl.chat.AddReaction(me, convID, msgID, c.Param("emoji"))
+	} else {
+		err = ctl.chat.RemoveReaction(me, convID, msgID, c.Param("emoji"))
+	}
+	if err != nil {
+		chatError(c, err, response.CodeMessageFailed, response.MsgReaction)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
+func memberPublic(m repository.MemberProfile) dto.MemberPublic {
+	return dto.MemberPublic{
+		UserID:    m.UserID,
+		Username:  m.Username,
+		AvatarURL: m.AvatarURL,
+		Role:      m.Role,
+		JoinedAt:  m.CreatedAt,
+
+		LastDeliveredMessageID: m.LastDeliveredMessageID,
+		LastReadMessageID:      m.LastReadMessageID,
+		LastReadAt:             m.LastReadAt,
+	}
+}
+
+func conversationSummary(conv repository.ConversationSummary, me string) dto.ConversationSummary {
+	out := dto.ConversationSummary{
+		ID:          conv.ID,
+		IsGroup:     conv.IsGroup,
+		Title:       conv.Title,
+		CreatedAt:   conv.CreatedAt,
+		UpdatedAt:   conv.UpdatedAt,
+		UnreadCount: conv.UnreadCount,
+	}
+
+	if conv.LastMessageID != nil {
+		preview := &dto.LastMessagePreview{
+			ID:      *conv.LastMessageID,
+			SentAt:  *conv.LastMessageSentAt,
+			Deleted: conv.LastMessageDeletedAt != nil,
+		}
+		if conv.LastMessageSenderID != nil {
+			preview.SenderID = *conv.LastMessageSenderID
+		}
+		if conv.LastMessageSenderName != nil {
+			preview.SenderName = *conv.LastMessageSenderName
+		}
+		if conv.LastMessageContent != nil {
+			preview.Content = *conv.LastMessageContent
+		}
+		out.LastMessage = preview
+	}
+
+	if conv.IsGroup {
+		out.Members = make([]dto.MemberPublic, 0, len(conv.Members))
+		for _, m := range conv.Members {
+			out.Members = append(out.Members, memberPublic(m))
+		}
+		return out
+	}
+
+	for _, m := range conv.Members {
+		if m.UserID != me {
+			other := memberPublic(m)
+			out.OtherParticipant = &other
+			break
+		}
+	}
+	return out
+}
+
+func conversationIDParam(c *gin.Context) (uint, bool) {
+	convID64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || convID64 == 0 {
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidConversation)
+		return 0, false
+	}
+	return uint(convID64), true
+}
+
+func messageIDParam(c *gin.Context) (uint, bool) {
+	msgID64, err := strconv.ParseUint(c.Param("messageId"), 10, 64)
+	if err != nil || msgID64 == 0 {
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidMessage)
+		return 0, false
+	}
+	return uint(msgID64), true
+}
+
+// pageParams reads the limit and beforeId cursor shared by message listings.
+func pageParams(c *gin.Context) (int, *uint) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	var beforeID *uint
+	if v := c.Query("beforeId"); v != "" {
+		b, err := strconv.ParseUint(v, 10, 64)
+		if err == nil && b > 0 {
+			tmp := uint(b)
+			beforeID = &tmp
+		}
+	}
+	return limit, beforeID
+}
+
+// chatError maps ChatService errors to HTTP responses, falling back to a 500
+// with the given code and message.
+func chatError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		response.Error(c, http.StatusForbidden, response.CodeForbidden, response.MsgForbidden)
+	case errors.Is(err, repository.ErrMemberNotFound):
+		response.Error(c, http.StatusNotFound, response.CodeNotFound, response.MsgMemberNotFound)
+	case errors.Is(err, repository.ErrMessageNotFound):
+		response.Error(c, http.StatusNotFound, response.CodeNotFound, response.MsgMessageNotFound)
+	case errors.Is(err, service.ErrEditWindowExpired):
+		response.Error(c, http.StatusForbidden, response.CodeEditWindowExpired, response.MsgEditWindowExpired)
+	case errors.Is(err, service.ErrNotGroup):
+		response.Error(c, http.StatusBadRequest, response.CodeNotGroup, response.MsgNotGroup)
+	case errors.Is(err, service.ErrUnknownUser):
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgUnknownUser)
+	case errors.Is(err, service.ErrInvalidRole):
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidRole)
+	case errors.Is(err, service.ErrInvalidScope):
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidDeleteScope)
+	case errors.Is(err, service.ErrInvalidReference):
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidReference)
+	case errors.Is(err, service.ErrEmptyMessage):
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgEmptyMessage)
+	case errors.Is(err, service.ErrClientIDReused):
+		response.Error(c, http.StatusConflict, response.CodeClientIDReused, response.MsgClientIDReused)
+	case errors.Is(err, repository.ErrAttachmentNotFound), errors.Is(err, storage.ErrObjectNotFound):
+		response.Error(c, http.StatusNotFound, response.CodeNotFound, response.MsgAttachmentNotFound)
+	case errors.Is(err, service.ErrFileTooLarge):
+		response.Error(c, http.StatusRequestEntityTooLarge, response.CodeFileTooLarge, response.MsgFileTooLarge)
+	case errors.Is(err, service.ErrFileTypeNotAllowed):
+		response.Error(c, http.StatusUnsupportedMediaType, response.CodeUnsupportedFileType, response.MsgUnsupportedFileType)
+	case errors.Is(err, service.ErrInvalidEmoji):
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidEmoji)
+	default:
+		response.Error(c, http.StatusInternalServerError, code, message)
+	}
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"talk-backend/internal/http/dto"
+	"talk-backend/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSController struct {
+	keys *service.KeyService
+}
+
+func NewJWKSController(keys *service.KeyService) *JWKSController {
+	return &JWKSController{keys: keys}
+}
+
+// Keys godoc
+// @Summary JSON Web Key Set
+// @Description Return the public keys access tokens are signed with, selected by the "kid" token header. The set includes the next key before it starts signing and replaced keys until their tokens have expired.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} dto.JWKSResponse
+// @Router /.well-known/jwks.json [get]
+func (ctl *JWKSController) Keys(c *gin.Context) {
+	keys := ctl.keys.PublicKeys()
+	out := dto.JWKSResponse{Keys: make([]dto.JWK, 0, len(keys))}
+	for _, k := range keys {
+		jwk := dto.JWK{KID: k.KID, Use: "sig", Alg: k.Algorithm}
+		switch pub := k.Key.(type) {
+		case ed25519.PublicKey:
+			jwk.KTY, jwk.Crv, jwk.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.KTY = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		out.Keys = append(out.Keys, jwk)
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ctl.keys.CacheTTL().Seconds())))
+	c.JSON(http.StatusOK, out)
+}
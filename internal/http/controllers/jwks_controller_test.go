@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"talk-backend/internal/http/dto"
+	"talk-backend/internal/models"
+	"talk-backend/internal/repository"
+	"talk-backend/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// memSigningKeys keeps signing keys for one KeyService; keys never rotate
+// within a test.
+type memSigningKeys struct {
+	mu   sync.Mutex
+	keys []models.SigningKey
+}
+
+func (m *memSigningKeys) ListUsable(now time.Time) ([]models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.SigningKey(nil), m.keys...), nil
+}
+
+func (m *memSigningKeys) Rotate(now time.Time, decide repository.RotationFunc) (*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	next, _ := decide(m.keys)
+	if next != nil {
+		m.keys = append(m.keys, *next)
+	}
+	return next, nil
+}
+
+// jwkPublicKey rebuilds the public key a JWKS consumer would see.
+func jwkPublicKey(t *testing.T, k dto.JWK) any {
+	t.Helper()
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("%s: %v", k.KID, err)
+		}
+		return b
+	}
+	switch k.KTY {
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			t.Fatalf("crv = %q", k.Crv)
+		}
+		return ed25519.PublicKey(decode(k.X))
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decode(k.N)),
+			E: int(new(big.Int).SetBytes(decode(k.E)).Int64()),
+		}
+	}
+	t.Fatalf("kty = %q", k.KTY)
+	return nil
+}
+
+func TestJWKSVerifiesIssuedTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		alg string
+		kty string
+	}{
+		{alg: "EdDSA", kty: "OKP"},
+		{alg: "RS256", kty: "RSA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			keys, err := service.NewKeyService(&memSigningKeys{}, service.KeyConfig{
+				Algorithm:  tt.alg,
+				Secret:     "secret",
+				Rotation:   24 * time.Hour,
+				Prepublish: time.Hour,
+				Grace:      time.Hour,
+				Refresh:    time.Minute,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := keys.Sign(jwt.RegisteredClaims{Subject: "u1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := gin.New()
+			r.GET("/.well-known/jwks.json", NewJWKSController(keys).Keys)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d", w.Code)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "public, max-age=1770" {
+				t.Errorf("Cache-Control = %q", got)
+			}
+			var set dto.JWKSResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+				t.Fatal(err)
+			}
+			if len(set.Keys) != 1 {
+				t.Fatalf("got %d keys, want 1", len(set.Keys))
+			}
+			k := set.Keys[0]
+			if k.KTY != tt.kty || k.Alg != tt.alg || k.Use != "sig" {
+				t.Errorf("jwk = %+v", k)
+			}
+
+			// A verifier that only has the JWKS accepts the token.
+			_, err = jwt.Parse(token, func(tok *jwt.Token) (any, error) {
+				if tok.Header["kid"] != k.KID {
+					t.Errorf("token kid %v not in the set", tok.Header["kid"])
+				}
+				return jwkPublicKey(t, k), nil
+			}, jwt.WithValidMethods([]string{k.Alg}))
+			if err != nil {
+				t.Errorf("token does not verify with the published key: %v", err)
+			}
+		})
+	}
+}
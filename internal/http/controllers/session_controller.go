@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"talk-backend/internal/http/dto"
+	"talk-backend/internal/http/middleware"
+	"talk-backend/internal/http/response"
+	"talk-backend/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionController struct {
+	sessions *service.SessionService
+}
+
+func NewSessionController(sessions *service.SessionService) *SessionController {
+	return &SessionController{sessions: sessions}
+}
+
+// List godoc
+// @Summary List my sessions
+// @Description Return the devices currently signed in to the caller's account. The session of the request is flagged as current.
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.SessionsResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/sessions [get]
+func (ctl *SessionController) List(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+
+	sessions, err := ctl.sessions.List(principal.UserID, principal.SessionID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgListSessions)
+		return
+	}
+
+	out := dto.SessionsResponse{Sessions: make([]dto.SessionResponse, 0, len(sessions))}
+	for _, s := range sessions {
+		out.Sessions = append(out.Sessions, dto.SessionResponse{
+			ID:         s.ID,
+			DeviceName: s.DeviceName,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.Current,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// Revoke godoc
+// @Summary Revoke a session
+// @Description Sign a device out: its refresh token stops working and its WebSocket and SSE connections are closed.
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/sessions/{id} [delete]
+func (ctl *SessionController) Revoke(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	if err := ctl.sessions.Revoke(me, c.Param("id"), clientIP(c), userAgent(c)); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			response.Error(c, http.StatusNotFound, response.CodeNotFound, response.MsgSessionNotFound)
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgRevokeSession)
+		return
+	}
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: response.MsgOK})
+}
+
+// RevokeOthers godoc
+// @Summary Revoke my other sessions
+// @Description Sign out every device except the one making the request.
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.RevokeSessionsResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/sessions/revoke-others [post]
+func (ctl *SessionController) RevokeOthers(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	// Without a session the caller's own device cannot be told apart.
+	if principal.SessionID == "" {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgSessionRequired)
+		return
+	}
+
+	n, err := ctl.sessions.RevokeOthers(principal.UserID, principal.SessionID, clientIP(c), userAgent(c))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgRevokeSession)
+		return
+	}
+	c.JSON(http.StatusOK, dto.RevokeSessionsResponse{Revoked: n})
+}
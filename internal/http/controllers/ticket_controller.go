@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"talk-backend/internal/http/dto"
+	"talk-backend/internal/http/middleware"
+	"talk-backend/internal/http/response"
+	"talk-backend/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TicketController struct {
+	tickets *service.TicketService
+}
+
+func NewTicketController(tickets *service.TicketService) *TicketController {
+	return &TicketController{tickets: tickets}
+}
+
+// Issue godoc
+// @Summary Get a WebSocket ticket
+// @Description Issue a single-use ticket to open /ws?ticket=... from a browser, which cannot set the Authorization header on the handshake. The ticket expires after about 30 seconds. With conversationId the socket only receives that conversation.
+// @Tags realtime
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.WSTicketRequest false "Optional conversation binding"
+// @Success 201 {object} dto.WSTicketResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/ws/ticket [post]
+func (ctl *TicketController) Issue(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+
+	var req dto.WSTicketRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.InvalidBody(c, err)
+		return
+	}
+
+	ticket, expiresAt, err := ctl.tickets.Issue(principal, req.ConversationID)
+	if err != nil {
+		if errors.Is(err, service.ErrForbidden) {
+			response.Error(c, http.StatusForbidden, response.CodeForbidden, response.MsgForbidden)
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgIssueTicket)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.WSTicketResponse{Ticket: ticket, ExpiresAt: expiresAt})
+}
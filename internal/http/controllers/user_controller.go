@@ -12,11 +12,12 @@ import (
 )
 
 type UserController struct {
-	user *service.UserService
+	user     *service.UserService
+	presence *service.PresenceService
 }
 
-func NewUserController(user *service.UserService) *UserController {
-	return &UserController{user: user}
+func NewUserController(user *service.UserService, presence *service.PresenceService) *UserController {
+	return &UserController{user: user, presence: presence}
 }
 
 // Me godoc
@@ -30,11 +31,12 @@ func NewUserController(user *service.UserService) *UserController {
 // @Failure 404 {object} dto.ErrorResponse
 // @Router /api/me [get]
 func (ctl *UserController) Me(c *gin.Context) {
-	userID, ok := middleware.GetUserID(c)
+	principal, ok := middleware.GetPrincipal(c)
 	if !ok {
 		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
 		return
 	}
+	userID := principal.UserID
 
 	user, err := ctl.user.GetMe(userID)
 	if err != nil {
@@ -52,3 +54,46 @@ func (ctl *UserController) Me(c *gin.Context) {
 		},
 	})
 }
+
+// Presence godoc
+// @Summary Get users' presence
+// @Description Return online/away/offline status and last-seen time for the given users. Only users sharing a conversation with the caller are returned.
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Param ids query string true "Comma-separated user IDs (max 100)"
+// @Success 200 {object} dto.PresenceResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/users/presence [get]
+func (ctl *UserController) Presence(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	me := principal.UserID
+
+	var q dto.PresenceQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidUserIDs)
+		return
+	}
+
+	states, err := ctl.presence.Lookup(me, q.IDs)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgGetPresence)
+		return
+	}
+
+	out := dto.PresenceResponse{Users: make([]dto.UserPresence, 0, len(states))}
+	for _, p := range states {
+		out.Users = append(out.Users, dto.UserPresence{
+			UserID:     p.UserID,
+			Status:     p.Status,
+			LastSeenAt: p.LastSeenAt,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
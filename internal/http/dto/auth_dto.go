@@ -10,6 +10,8 @@ type RegisterRequest struct {
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	// DeviceName labels the session in GET /api/sessions.
+	DeviceName string `json:"deviceName" binding:"omitempty,max=100"`
 }
 
 type RefreshRequest struct {
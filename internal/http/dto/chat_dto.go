@@ -1,9 +1,61 @@
 package dto
 
+import "time"
+
 type DirectConversationRequest struct {
 	UserID string `json:"userId" binding:"required,uuid"`
 }
 
+type GroupConversationRequest struct {
+	Title     string   `json:"title" binding:"required,min=1,max=100"`
+	MemberIDs []string `json:"memberIds" binding:"required,min=1,max=256,dive,uuid"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin member"`
+}
+
+type MemberURI struct {
+	UserID string `uri:"userId" binding:"required,uuid"`
+}
+
+type AddMembersRequest struct {
+	UserIDs []string `json:"userIds" binding:"required,min=1,max=256,dive,uuid"`
+}
+
+type TransferOwnershipRequest struct {
+	UserID string `json:"userId" binding:"required,uuid"`
+}
+
 type SendMessageRequest struct {
+	Content       string `json:"content" binding:"max=4000"`
+	ReplyTo       *uint  `json:"replyTo" binding:"omitempty,min=1"`
+	ThreadRoot    *uint  `json:"threadRoot" binding:"omitempty,min=1"`
+	AttachmentIDs []uint `json:"attachmentIds" binding:"omitempty,max=10,dive,min=1"`
+
+	// ClientMessageID is an optional idempotency key; resending with the same
+	// key returns the original message.
+	ClientMessageID string `json:"clientMessageId" binding:"omitempty,max=64"`
+}
+
+type EditMessageRequest struct {
 	Content string `json:"content" binding:"required,min=1,max=4000"`
 }
+
+type DeleteMessageQuery struct {
+	Scope string `form:"scope" binding:"omitempty,oneof=me everyone"`
+}
+
+type MarkReadRequest struct {
+	MessageID uint `json:"messageId" binding:"required,min=1"`
+}
+
+type SearchMessagesQuery struct {
+	Q              string    `form:"q" binding:"required,min=1,max=200"`
+	ConversationID uint      `form:"conversationId" binding:"omitempty,min=1"`
+	SenderID       string    `form:"senderId" binding:"omitempty,uuid"`
+	From           time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To             time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit          int       `form:"limit" binding:"omitempty,min=1,max=100"`
+	BeforeID       uint      `form:"beforeId" binding:"omitempty,min=1"`
+}
@@ -0,0 +1,18 @@
+package dto
+
+// JWK is a public key in JSON Web Key form (RFC 7517). Ed25519 keys use Crv
+// and X, RSA keys N and E.
+type JWK struct {
+	KTY string `json:"kty"`
+	KID string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSResponse struct {
+	Keys []JWK `json:"keys"`
+}
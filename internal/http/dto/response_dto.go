@@ -40,12 +40,66 @@ type MeResponse struct {
 	User UserMe `json:"user"`
 }
 
+type UserPresence struct {
+	UserID     string     `json:"userId"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+type PresenceResponse struct {
+	Users []UserPresence `json:"users"`
+}
+
 type ConversationResponse struct {
 	Conversation models.Conversation `json:"conversation"`
 }
 
+type LastMessagePreview struct {
+	ID         uint      `json:"id"`
+	SenderID   string    `json:"senderId"`
+	SenderName string    `json:"senderName"`
+	Content    string    `json:"content"`
+	SentAt     time.Time `json:"sentAt"`
+	Deleted    bool      `json:"deleted"`
+}
+
+type ConversationSummary struct {
+	ID        uint      `json:"id"`
+	IsGroup   bool      `json:"isGroup"`
+	Title     *string   `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	LastMessage *LastMessagePreview `json:"lastMessage"`
+	UnreadCount int64               `json:"unreadCount"`
+
+	// Members is set for groups, OtherParticipant for direct conversations.
+	Members          []MemberPublic `json:"members,omitempty"`
+	OtherParticipant *MemberPublic  `json:"otherParticipant,omitempty"`
+}
+
 type ConversationsResponse struct {
-	Conversations []models.Conversation `json:"conversations"`
+	Conversations []ConversationSummary `json:"conversations"`
+}
+
+type MemberPublic struct {
+	UserID    string    `json:"userId"`
+	Username  string    `json:"username"`
+	AvatarURL string    `json:"avatarUrl"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joinedAt"`
+
+	LastDeliveredMessageID *uint      `json:"lastDeliveredMessageId"`
+	LastReadMessageID      *uint      `json:"lastReadMessageId"`
+	LastReadAt             *time.Time `json:"lastReadAt"`
+}
+
+type MembersResponse struct {
+	Members []MemberPublic `json:"members"`
+}
+
+type AddMembersResponse struct {
+	Added []string `json:"added"`
 }
 
 type MessageResponseData struct {
@@ -56,6 +110,31 @@ type MessagesResponse struct {
 	Messages []models.Message `json:"messages"`
 }
 
+type SearchResult struct {
+	MessageID      uint      `json:"messageId"`
+	ConversationID uint      `json:"conversationId"`
+	SenderID       string    `json:"senderId"`
+	SentAt         time.Time `json:"sentAt"`
+	// Snippet is HTML-escaped text with matches in <mark> tags; it is safe
+	// to render as HTML.
+	Snippet string `json:"snippet"`
+}
+
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	// NextBeforeID is the cursor for the next page, absent on the last one.
+	NextBeforeID *uint `json:"nextBeforeId,omitempty"`
+}
+
+type AttachmentResponse struct {
+	Attachment models.Attachment `json:"attachment"`
+}
+
+type ThreadResponse struct {
+	Root     models.Message   `json:"root"`
+	Messages []models.Message `json:"messages"`
+}
+
 type LogoutRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
@@ -0,0 +1,21 @@
+package dto
+
+import "time"
+
+type SessionResponse struct {
+	ID         string     `json:"id"`
+	DeviceName string     `json:"deviceName"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"userAgent"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	Current    bool       `json:"current"`
+}
+
+type SessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
@@ -0,0 +1,12 @@
+package dto
+
+import "time"
+
+type WSTicketRequest struct {
+	ConversationID *uint `json:"conversationId" binding:"omitempty,min=1"`
+}
+
+type WSTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
@@ -0,0 +1,5 @@
+package dto
+
+type PresenceQuery struct {
+	IDs []string `form:"ids" collection_format:"csv" binding:"required,min=1,max=100,dive,uuid"`
+}
@@ -3,22 +3,27 @@ package middleware
 import (
 	"log"
 	"net/http"
-	"regexp"
 	"strings"
+	"talk-backend/internal/auth"
 	"talk-backend/internal/http/response"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-const CtxUserIDKey = "userID"
+const CtxPrincipalKey = "principal"
 
-var uuidV4LikeRe = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[1-5][a-fA-F0-9]{3}-[89abAB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$`)
+// TokenChecker tells whether a validly signed access token was revoked since,
+// by a logout or a bump of the user's token version.
+type TokenChecker interface {
+	Check(userID, sessionID string, version int) (bool, error)
+}
 
-func RequireAuth(jwtSecret string) gin.HandlerFunc {
+// RequireAuth accepts requests with a valid bearer access token that was not
+// revoked, and stores its Principal for GetPrincipal.
+func RequireAuth(verifier *auth.Verifier, checker TokenChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		auth := c.GetHeader("Authorization")
-		if auth == "" {
+		header := c.GetHeader("Authorization")
+		if header == "" {
 			log.Println("[AUTH] No Authorization header")
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"code":  response.CodeUnauthorized,
@@ -27,7 +32,7 @@ func RequireAuth(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		parts := strings.SplitN(auth, " ", 2)
+		parts := strings.SplitN(header, " ", 2)
 		if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
 			log.Println("[AUTH] Invalid Authorization format")
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
@@ -37,18 +42,9 @@ func RequireAuth(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		tokenStr := parts[1]
-		log.Printf("[AUTH] Token received: %s...", tokenStr[:min(20, len(tokenStr))])
-
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
-			if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(jwtSecret), nil
-		})
-
+		principal, err := verifier.Verify(parts[1])
 		if err != nil {
-			log.Printf("[AUTH] Token parse error: %v", err)
+			log.Printf("[AUTH] %v", err)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"code":  response.CodeUnauthorized,
 				"error": response.MsgUnauthorized,
@@ -56,51 +52,17 @@ func RequireAuth(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		if token == nil || !token.Valid {
-			log.Println("[AUTH] Token is nil or invalid")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"code":  response.CodeUnauthorized,
-				"error": response.MsgUnauthorized,
-			})
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			log.Println("[AUTH] Cannot get claims")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"code":  response.CodeUnauthorized,
-				"error": response.MsgUnauthorized,
-			})
-			return
-		}
-
-		sub, ok := claims["sub"]
-		if !ok {
-			log.Println("[AUTH] No sub claim")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"code":  response.CodeUnauthorized,
-				"error": response.MsgUnauthorized,
+		valid, err := checker.Check(principal.UserID, principal.SessionID, principal.Version)
+		if err != nil {
+			log.Printf("[AUTH] Cannot check revocation: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"code":  response.CodeInternal,
+				"error": response.MsgInternalServer,
 			})
 			return
 		}
-
-		log.Printf("[AUTH] sub claim: %v (type: %T)", sub, sub)
-
-		var userID string
-		switch v := sub.(type) {
-		case string:
-			if !isUUID(v) {
-				log.Printf("[AUTH] Invalid UUID sub: %s", v)
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"code":  response.CodeUnauthorized,
-					"error": response.MsgUnauthorized,
-				})
-				return
-			}
-			userID = v
-		default:
-			log.Printf("[AUTH] Unknown sub type: %T", sub)
+		if !valid {
+			log.Printf("[AUTH] Token revoked for userID=%s", principal.UserID)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"code":  response.CodeUnauthorized,
 				"error": response.MsgUnauthorized,
@@ -108,21 +70,18 @@ func RequireAuth(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		log.Printf("[AUTH] Success! userID=%s", userID)
-		c.Set(CtxUserIDKey, userID)
+		log.Printf("[AUTH] Success! userID=%s", principal.UserID)
+		c.Set(CtxPrincipalKey, principal)
 		c.Next()
 	}
 }
 
-func GetUserID(c *gin.Context) (string, bool) {
-	v, ok := c.Get(CtxUserIDKey)
+// GetPrincipal returns the caller authenticated by RequireAuth.
+func GetPrincipal(c *gin.Context) (*auth.Principal, bool) {
+	v, ok := c.Get(CtxPrincipalKey)
 	if !ok {
-		return "", false
+		return nil, false
 	}
-	id, ok := v.(string)
-	return id, ok
-}
-
-func isUUID(v string) bool {
-	return uuidV4LikeRe.MatchString(v)
+	p, ok := v.(*auth.Principal)
+	return p, ok
 }
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"talk-backend/internal/http/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LimitBody caps request bodies at max bytes. exempt lists route patterns,
+// as returned by c.FullPath, whose handlers apply a limit of their own, such
+// as attachment uploads.
+func LimitBody(max int64, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(exempt, c.FullPath()) {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > max {
+			response.Error(c, http.StatusRequestEntityTooLarge, response.CodeBodyTooLarge, response.MsgBodyTooLarge)
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+		c.Next()
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"talk-backend/internal/http/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLimitBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const limit = 16
+
+	read := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			response.InvalidBody(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+	r := gin.New()
+	r.Use(LimitBody(limit, "/upload/:id"))
+	r.POST("/json", read)
+	r.POST("/upload/:id", read)
+
+	small := strings.Repeat("a", limit)
+	large := strings.Repeat("a", limit+1)
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		contentType string
+		chunked     bool
+		want        int
+	}{
+		{name: "within limit", path: "/json", body: small, contentType: "application/json", want: http.StatusNoContent},
+		{name: "declared length over limit", path: "/json", body: large, contentType: "application/json", want: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over limit", path: "/json", body: large, contentType: "application/json", chunked: true, want: http.StatusRequestEntityTooLarge},
+		{name: "multipart header does not lift the limit", path: "/json", body: large, contentType: "multipart/form-data; boundary=x", want: http.StatusRequestEntityTooLarge},
+		{name: "chunked multipart does not lift the limit", path: "/json", body: large, contentType: "multipart/form-data; boundary=x", chunked: true, want: http.StatusRequestEntityTooLarge},
+		{name: "exempt route", path: "/upload/1", body: large, contentType: "multipart/form-data; boundary=x", want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.chunked {
+				// Unknown length, so only the reader can enforce the limit.
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), response.CodeBodyTooLarge) {
+				t.Fatalf("body = %s, want code %s", w.Body, response.CodeBodyTooLarge)
+			}
+		})
+	}
+}
@@ -12,6 +12,13 @@ const (
 	CodeInvalidRefreshToken = "INVALID_REFRESH_TOKEN"
 	CodeConversationFailed  = "CONVERSATION_OPERATION_FAILED"
 	CodeMessageFailed       = "MESSAGE_OPERATION_FAILED"
+	CodeNotGroup            = "NOT_GROUP_CONVERSATION"
+	CodeEditWindowExpired   = "EDIT_WINDOW_EXPIRED"
+	CodeAttachmentFailed    = "ATTACHMENT_OPERATION_FAILED"
+	CodeFileTooLarge        = "FILE_TOO_LARGE"
+	CodeBodyTooLarge        = "BODY_TOO_LARGE"
+	CodeUnsupportedFileType = "UNSUPPORTED_FILE_TYPE"
+	CodeClientIDReused      = "CLIENT_MESSAGE_ID_REUSED"
 	CodeInternal            = "INTERNAL_ERROR"
 )
 
@@ -21,16 +28,64 @@ const (
 	MsgForbidden            = "You do not have access to this resource."
 	MsgUserNotFound         = "User not found."
 	MsgInvalidConversation  = "Conversation ID must be a positive integer."
+	MsgInvalidUserID        = "User ID must be a valid UUID."
+	MsgUnknownUser          = "Every user ID must belong to an existing user."
+	MsgMemberNotFound       = "Member not found."
+	MsgInvalidMessage       = "Message ID must be a positive integer."
+	MsgMessageNotFound      = "Message not found."
+	MsgEditWindowExpired    = "This message can no longer be edited."
+	MsgInvalidDeleteScope   = "Scope must be either me or everyone."
+	MsgInvalidEmoji         = "Emoji must be a short token without spaces."
+	MsgInvalidReference     = "Referenced message does not belong to this conversation."
+	MsgEmptyMessage         = "A message needs content or at least one attachment."
+	MsgClientIDReused       = "This clientMessageId was already used in another conversation."
+	MsgInvalidClientID      = "clientMessageId must be at most 64 characters."
+	MsgInvalidFrame         = "Frame is not valid JSON or has an unknown type."
+	MsgUnsupportedVersion   = "Unsupported protocol version."
+	MsgInvalidPresence      = "Status must be either online or away."
+	MsgInvalidTicket        = "Ticket is invalid, expired or already used."
+	MsgSessionNotFound      = "Session not found."
+	MsgSessionRequired      = "This access token has no session; refresh it first."
+	MsgSessionRevoked       = "Session revoked."
+	MsgTokenExpired         = "Access token expired; send a fresh one in an auth frame."
+	MsgInvalidAttachment    = "Attachment ID must be a positive integer."
+	MsgAttachmentNotFound   = "Attachment not found."
+	MsgFileTooLarge         = "File exceeds the maximum allowed size."
+	MsgBodyTooLarge         = "Request body exceeds the maximum allowed size."
+	MsgUnsupportedFileType  = "This file type is not allowed."
+	MsgInvalidRole          = "Role must be either admin or member."
+	MsgNotGroup             = "This operation is only available for group conversations."
 	MsgConversationRequired = "conversationId query parameter is required."
+	MsgInvalidUserIDs       = "ids must be a comma-separated list of up to 100 user UUIDs."
 	MsgTooManyRequests      = "Too many requests. Please try again later."
+	MsgTooManyConnections   = "Too many open connections."
+	MsgTooManyFrames        = "Too many frames."
 	MsgEmailAlreadyExists   = "A user with this email already exists."
 	MsgRegisterFailed       = "Failed to register user."
 	MsgInvalidCredentials   = "Invalid email or password."
 	MsgInvalidRefreshToken  = "Invalid refresh token."
 	MsgCreateConversation   = "Failed to create conversation."
 	MsgListConversations    = "Failed to list conversations."
+	MsgUpdateMember         = "Failed to update member."
+	MsgListMembers          = "Failed to list members."
+	MsgAddMembers           = "Failed to add members."
+	MsgRemoveMember         = "Failed to remove member."
+	MsgLeaveConversation    = "Failed to leave conversation."
 	MsgSendMessage          = "Failed to send message."
 	MsgGetMessages          = "Failed to get messages."
+	MsgGetThread            = "Failed to get thread."
+	MsgSearchMessages       = "Failed to search messages."
+	MsgEditMessage          = "Failed to edit message."
+	MsgDeleteMessage        = "Failed to delete message."
+	MsgMarkRead             = "Failed to mark conversation as read."
+	MsgReaction             = "Failed to update reaction."
+	MsgUploadAttachment     = "Failed to upload attachment."
+	MsgDownloadAttachment   = "Failed to download attachment."
+	MsgGetPresence          = "Failed to get presence."
+	MsgIssueTicket          = "Failed to issue ticket."
+	MsgListSessions         = "Failed to list sessions."
+	MsgRevokeSession        = "Failed to revoke session."
+	MsgLogoutAll            = "Failed to log out everywhere."
 	MsgInternalServer       = "Internal server error."
 )
 
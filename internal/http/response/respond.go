@@ -1,6 +1,7 @@
 package response
 
 import (
+	"errors"
 	"net/http"
 
 	"talk-backend/internal/http/dto"
@@ -24,5 +25,10 @@ func ErrorWithDetails(c *gin.Context, status int, code, message, details string)
 }
 
 func InvalidBody(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Error(c, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, MsgBodyTooLarge)
+		return
+	}
 	ErrorWithDetails(c, http.StatusBadRequest, CodeInvalidRequest, MsgInvalidRequestBody, err.Error())
 }
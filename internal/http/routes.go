@@ -12,10 +12,18 @@ import (
 	"golang.org/x/time/rate"
 )
 
-func RegisterRoutes(r *gin.Engine, app *container.App, jwtSecret string) {
+// uploadRoute takes bodies up to the attachment size limit, which its handler
+// enforces; every other route is held to maxBody.
+const uploadRoute = "/api/conversations/:id/attachments"
+
+func RegisterRoutes(r *gin.Engine, app *container.App, maxBody int64) {
+	r.Use(middleware.LimitBody(maxBody, uploadRoute))
+
 	loginLimiter := middleware.NewIPLimiter(rate.Every(12*time.Second), 10)
+	requireAuth := middleware.RequireAuth(app.Verifier, app.TokenGuard)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/.well-known/jwks.json", app.JWKSController.Keys)
 	r.GET("/ws", app.WSHandler.Handle)
 	auth := r.Group("/auth")
 	{
@@ -23,20 +31,49 @@ func RegisterRoutes(r *gin.Engine, app *container.App, jwtSecret string) {
 		auth.POST("/login", loginLimiter.Middleware(), app.AuthController.Login)
 		auth.POST("/refresh", app.AuthController.Refresh)
 		auth.POST("/logout", app.AuthController.Logout)
+		auth.POST("/logout-all", requireAuth, app.AuthController.LogoutAll)
 	}
 
 	api := r.Group("/api")
-	api.Use(middleware.RequireAuth(jwtSecret))
+	api.Use(requireAuth)
 	{
 		// User routes
 		api.GET("/me", app.UserController.Me)
+		api.GET("/users/presence", app.UserController.Presence)
+
+		// Session routes
+		api.GET("/sessions", app.SessionController.List)
+		api.POST("/sessions/revoke-others", app.SessionController.RevokeOthers)
+		api.DELETE("/sessions/:id", app.SessionController.Revoke)
+
+		// Realtime routes
+		api.GET("/events", app.SSEHandler.Stream)
+		api.POST("/ws/ticket", app.TicketController.Issue)
 
 		// Conversation routes
 		api.POST("/conversations/direct", app.ChatController.CreateDirect)
+		api.POST("/conversations/group", app.ChatController.CreateGroup)
 		api.GET("/conversations", app.ChatController.ListMyConversations)
+		api.GET("/conversations/:id/members", app.ChatController.ListMembers)
+		api.POST("/conversations/:id/members", app.ChatController.AddMembers)
+		api.DELETE("/conversations/:id/members/:userId", app.ChatController.RemoveMember)
+		api.POST("/conversations/:id/leave", app.ChatController.Leave)
+		api.POST("/conversations/:id/read", app.ChatController.MarkRead)
+		api.PUT("/conversations/:id/members/:userId/role", app.ChatController.UpdateMemberRole)
+		api.POST("/conversations/:id/owner", app.ChatController.TransferOwnership)
 
 		// Message routes
 		api.POST("/conversations/:id/messages", app.ChatController.SendMessage)
 		api.GET("/conversations/:id/messages", app.ChatController.GetMessages)
+		api.PATCH("/conversations/:id/messages/:messageId", app.ChatController.EditMessage)
+		api.DELETE("/conversations/:id/messages/:messageId", app.ChatController.DeleteMessage)
+		api.POST("/conversations/:id/attachments", app.AttachmentController.Upload)
+		api.GET("/attachments/:attachmentId", app.AttachmentController.Download)
+		api.GET("/conversations/:id/messages/:messageId/thread", app.ChatController.GetThread)
+		api.PUT("/conversations/:id/messages/:messageId/reactions/:emoji", app.ChatController.AddReaction)
+		api.DELETE("/conversations/:id/messages/:messageId/reactions/:emoji", app.ChatController.RemoveReaction)
+
+		// Search routes
+		api.GET("/search/messages", app.ChatController.SearchMessages)
 	}
 }
@@ -0,0 +1,24 @@
+package http
+
+import (
+	"testing"
+
+	"talk-backend/internal/container"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The body limit exemption is keyed by route pattern, so it must name a
+// route that exists.
+func TestUploadRouteIsRegistered(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterRoutes(r, &container.App{}, 1<<20)
+
+	for _, route := range r.Routes() {
+		if route.Method == "POST" && route.Path == uploadRoute {
+			return
+		}
+	}
+	t.Fatalf("POST %s is not registered", uploadRoute)
+}
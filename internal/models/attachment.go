@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Attachment is a file uploaded to a conversation. It stays unattached
+// (MessageID nil) until the uploader sends a message referencing it.
+type Attachment struct {
+	ID             uint   `gorm:"primaryKey"`
+	ConversationID uint   `gorm:"index;not null"`
+	MessageID      *uint  `gorm:"index"`
+	UploaderID     string `gorm:"type:uuid;index;not null"`
+
+	FileName   string `gorm:"not null"`
+	MimeType   string `gorm:"not null"`
+	Size       int64  `gorm:"not null"`
+	Checksum   string `gorm:"size:64;not null"`
+	StorageKey string `json:"-" gorm:"uniqueIndex;not null"`
+
+	CreatedAt time.Time
+}
@@ -2,11 +2,26 @@ package models
 
 import "time"
 
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
 type ConversationMember struct {
 	ID             uint   `gorm:"primaryKey"`
-	ConversationID uint   `gorm:"index;not null"`
-	UserID         string `gorm:"type:uuid;index;not null"`
+	ConversationID uint   `gorm:"uniqueIndex:idx_conversation_member;not null"`
+	UserID         string `gorm:"type:uuid;uniqueIndex:idx_conversation_member;index;not null"`
 	Role           string `gorm:"not null;default:'member'"`
 
+	LastDeliveredMessageID *uint
+	LastReadMessageID      *uint
+	LastReadAt             *time.Time
+
 	CreatedAt time.Time
 }
+
+// CanManage reports whether the member may add or remove other members.
+func (m *ConversationMember) CanManage() bool {
+	return m.Role == RoleOwner || m.Role == RoleAdmin
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// HiddenMessage records a message a user deleted for themselves only.
+type HiddenMessage struct {
+	ID        uint   `gorm:"primaryKey"`
+	MessageID uint   `gorm:"uniqueIndex:idx_hidden_message_user;not null"`
+	UserID    string `gorm:"type:uuid;uniqueIndex:idx_hidden_message_user;not null"`
+
+	CreatedAt time.Time
+}
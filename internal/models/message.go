@@ -5,11 +5,25 @@ import "time"
 type Message struct {
 	ID             uint   `gorm:"primaryKey"`
 	ConversationID uint   `gorm:"index;not null"`
-	SenderID       string `gorm:"type:uuid;index;not null"`
+	SenderID       string `gorm:"type:uuid;index;not null;uniqueIndex:idx_messages_sender_client_id,priority:1"`
+
+	// ClientMessageID is an idempotency key chosen by the sender's client so
+	// a retried send is stored only once. It is never serialised, so other
+	// members cannot see it.
+	ClientMessageID *string `gorm:"size:64;uniqueIndex:idx_messages_sender_client_id,priority:2" json:"-"`
 
 	Content string    `gorm:"type:text;not null"`
 	SentAt  time.Time `gorm:"index;not null"`
 
-	DeliveredAt *time.Time
-	ReadAt      *time.Time
+	ReplyToID    *uint `gorm:"index"`
+	ThreadRootID *uint `gorm:"index"`
+
+	EditedAt  *time.Time
+	DeletedAt *time.Time
+	DeletedBy *string `gorm:"type:uuid"`
+
+	Attachments []Attachment `gorm:"foreignKey:MessageID"`
+
+	Reactions        []ReactionCount `gorm:"-"`
+	ThreadReplyCount int64           `gorm:"-"`
 }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type MessageEdit struct {
+	ID        uint `gorm:"primaryKey"`
+	MessageID uint `gorm:"index;not null"`
+
+	// Content holds the text the message had before this edit.
+	Content  string    `gorm:"type:text;not null"`
+	EditedBy string    `gorm:"type:uuid;not null"`
+	EditedAt time.Time `gorm:"not null"`
+}
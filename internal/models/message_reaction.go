@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type MessageReaction struct {
+	ID        uint   `gorm:"primaryKey"`
+	MessageID uint   `gorm:"uniqueIndex:idx_reaction_message_user_emoji;not null"`
+	UserID    string `gorm:"type:uuid;uniqueIndex:idx_reaction_message_user_emoji;not null"`
+	Emoji     string `gorm:"size:64;uniqueIndex:idx_reaction_message_user_emoji;not null"`
+
+	CreatedAt time.Time
+}
+
+// ReactionCount aggregates the reactions of one emoji on a message.
+type ReactionCount struct {
+	Emoji       string `json:"emoji"`
+	Count       int64  `json:"count"`
+	ReactedByMe bool   `json:"reactedByMe"`
+}
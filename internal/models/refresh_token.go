@@ -13,7 +13,18 @@ type RefreshToken struct {
 	ExpiresAt time.Time `gorm:"index;not null"`
 	RevokedAt *time.Time
 
+	// FamilyID is shared by every token rotated from the same login, so a
+	// replayed token can take the whole chain down with it.
+	FamilyID string `gorm:"size:32;index"`
+	// ReplacedByID points at the token this one was rotated into.
 	ReplacedByID *uint
 
+	// Device details of the login the family belongs to, refreshed on every
+	// rotation. The family is what users see as a session.
+	IP         string `gorm:"size:64"`
+	UserAgent  string `gorm:"size:512"`
+	DeviceName string `gorm:"size:100"`
+	LastUsedAt *time.Time
+
 	CreatedAt time.Time
 }
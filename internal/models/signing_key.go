@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// SigningKey is a key pair for access tokens. Keys live in the database so
+// every replica signs and verifies with the same set.
+type SigningKey struct {
+	ID uint `gorm:"primaryKey"`
+
+	// KID is sent in the token header and in the JWKS.
+	KID       string `gorm:"uniqueIndex;size:32;not null"`
+	Algorithm string `gorm:"size:16;not null"`
+
+	// PrivateKey is the PKCS #8 encoding, sealed with a key derived from
+	// JWT_SECRET.
+	PrivateKey []byte `gorm:"not null"`
+
+	// ActivatesAt is when the key starts signing. It is published before
+	// then, so verifiers already know it when the first token arrives.
+	ActivatesAt time.Time `gorm:"index;not null"`
+	// RetiresAt is when the key is no longer accepted. It is set once a
+	// successor is created, leaving a grace period for tokens it signed.
+	RetiresAt *time.Time `gorm:"index"`
+
+	CreatedAt time.Time
+}
@@ -21,6 +21,11 @@ type User struct {
 	LockedUntil         *time.Time `json:"-"`
 
 	LastLoginAt *time.Time `json:"-"`
+	LastSeenAt  *time.Time `json:"lastSeenAt,omitempty"`
+
+	// TokenVersion is embedded in access tokens; bumping it invalidates every
+	// token issued before.
+	TokenVersion int `json:"-" gorm:"not null;default:0"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// WSTicket lets a browser open a WebSocket, since it cannot send an
+// Authorization header on the handshake. It is redeemed once, shortly after
+// being issued.
+type WSTicket struct {
+	ID uint `gorm:"primaryKey"`
+
+	TokenHash string `gorm:"uniqueIndex;not null"`
+
+	UserID string `gorm:"type:uuid;index;not null"`
+	User   User   `gorm:"constraint:OnDelete:CASCADE;"`
+
+	// SessionID is the session of the access token the ticket was bought
+	// with, so revoking it also closes the socket.
+	SessionID string `gorm:"size:32"`
+	// TokenVersion is the user's token version in that access token, so a
+	// logout-all also voids tickets not redeemed yet.
+	TokenVersion int `gorm:"not null;default:0"`
+
+	// ConversationID, when set, restricts the socket to that conversation.
+	ConversationID *uint
+
+	// AuthExpiresAt is the expiry of the access token the ticket was bought
+	// with; the socket must present a fresh token before then.
+	AuthExpiresAt time.Time `gorm:"not null"`
+
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"talk-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+type AttachmentRepository interface {
+	Create(att *models.Attachment) error
+	FindByID(id uint) (*models.Attachment, error)
+	AttachToMessage(tx *gorm.DB, ids []uint, uploaderID string, msg *models.Message) error
+	DeletePendingBefore(cutoff time.Time) ([]string, error)
+}
+
+type attachmentRepository struct{ db *gorm.DB }
+
+func NewAttachmentRepository(db *gorm.DB) AttachmentRepository {
+	return &attachmentRepository{db: db}
+}
+
+func (r *attachmentRepository) Create(att *models.Attachment) error {
+	return r.db.Create(att).Error
+}
+
+func (r *attachmentRepository) FindByID(id uint) (*models.Attachment, error) {
+	var att models.Attachment
+	err := r.db.Where("id = ?", id).First(&att).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &att, nil
+}
+
+// AttachToMessage links pending uploads to msg and loads them into
+// msg.Attachments. Every ID must be an unattached upload by uploaderID in the
+// message's conversation, otherwise ErrAttachmentNotFound is returned.
+func (r *attachmentRepository) AttachToMessage(tx *gorm.DB, ids []uint, uploaderID string, msg *models.Message) error {
+	res := tx.Model(&models.Attachment{}).
+		Where("id IN ? AND uploader_id = ? AND conversation_id = ? AND message_id IS NULL", ids, uploaderID, msg.ConversationID).
+		Update("message_id", msg.ID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != int64(len(ids)) {
+		return ErrAttachmentNotFound
+	}
+	return tx.Where("message_id = ?", msg.ID).Order("id ASC").Find(&msg.Attachments).Error
+}
+
+// DeletePendingBefore removes uploads created before cutoff that were never
+// sent in a message and returns their storage keys. An upload being attached
+// concurrently is either attached first or deleted first, never both.
+func (r *attachmentRepository) DeletePendingBefore(cutoff time.Time) ([]string, error) {
+	var removed []models.Attachment
+	err := r.db.Clauses(clause.Returning{Columns: []clause.Column{{Name: "storage_key"}}}).
+		Where("message_id IS NULL AND created_at < ?", cutoff).
+		Delete(&removed).Error
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(removed))
+	for _, att := range removed {
+		keys = append(keys, att.StorageKey)
+	}
+	return keys, nil
+}
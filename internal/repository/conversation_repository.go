@@ -1,16 +1,70 @@
 package repository
 
 import (
+	"errors"
+	"time"
+
 	"talk-backend/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrConversationNotFound = errors.New("conversation not found")
+var ErrMemberNotFound = errors.New("member not found")
+
+// MemberProfile is a conversation member joined with its public user fields.
+type MemberProfile struct {
+	ConversationID uint
+	UserID         string
+	Username       string
+	AvatarURL      string
+	Role           string
+
+	LastDeliveredMessageID *uint
+	LastReadMessageID      *uint
+	LastReadAt             *time.Time
+
+	CreatedAt time.Time
+}
+
+// ConversationSummary is a conversation as seen by one member: its latest
+// visible message, that member's unread count and the member roster.
+type ConversationSummary struct {
+	ID        uint
+	IsGroup   bool
+	Title     *string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	LastMessageID         *uint
+	LastMessageSenderID   *string
+	LastMessageSenderName *string
+	LastMessageContent    *string
+	LastMessageSentAt     *time.Time
+	LastMessageDeletedAt  *time.Time
+
+	UnreadCount int64
+
+	Members []MemberProfile `gorm:"-"`
+}
+
 type ConversationRepository interface {
 	CreateConversation(tx *gorm.DB, conv *models.Conversation) error
+	FindByID(conversationID uint) (*models.Conversation, error)
 	AddMembers(tx *gorm.DB, members []models.ConversationMember) error
+	AddNewMembers(tx *gorm.DB, members []models.ConversationMember) ([]string, error)
 	IsMember(conversationID uint, userID string) (bool, error)
-	ListUserConversations(userID string) ([]models.Conversation, error)
+	GetMember(conversationID uint, userID string) (*models.ConversationMember, error)
+	UpdateMemberRole(tx *gorm.DB, conversationID uint, userID string, role string) error
+	RemoveMember(tx *gorm.DB, conversationID uint, userID string) error
+	ListMembers(conversationID uint) ([]MemberProfile, error)
+	AdvanceDeliveredCursor(conversationID uint, userID string, messageID uint) (bool, error)
+	AdvanceReadCursor(conversationID uint, userID string, messageID uint, when time.Time) (bool, error)
+	Touch(tx *gorm.DB, conversationID uint, when time.Time) error
+	ListUserConversations(userID string) ([]ConversationSummary, error)
+	ListUserConversationIDs(userID string) ([]uint, error)
+	ListContactIDs(userID string) ([]string, error)
 
 	FindDirectConversation(userA string, userB string) (*models.Conversation, error)
 }
@@ -25,10 +79,45 @@ func (r *conversationRepository) CreateConversation(tx *gorm.DB, conv *models.Co
 	return tx.Create(conv).Error
 }
 
+func (r *conversationRepository) FindByID(conversationID uint) (*models.Conversation, error) {
+	var conv models.Conversation
+	err := r.db.Where("id = ?", conversationID).First(&conv).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		return nil, err
+	}
+	return &conv, nil
+}
+
 func (r *conversationRepository) AddMembers(tx *gorm.DB, members []models.ConversationMember) error {
 	return tx.Create(&members).Error
 }
 
+// AddNewMembers inserts the members that are not in their conversation yet
+// and returns their user IDs. The unique index on (conversation_id, user_id)
+// keeps concurrent adds of the same user from creating two memberships.
+func (r *conversationRepository) AddNewMembers(tx *gorm.DB, members []models.ConversationMember) ([]string, error) {
+	added := []string{}
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		for i := range members {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members[i])
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				added = append(added, members[i].UserID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
 func (r *conversationRepository) IsMember(conversationID uint, userID string) (bool, error) {
 	var count int64
 	err := r.db.Model(&models.ConversationMember{}).
@@ -37,14 +126,166 @@ func (r *conversationRepository) IsMember(conversationID uint, userID string) (b
 	return count > 0, err
 }
 
-func (r *conversationRepository) ListUserConversations(userID string) ([]models.Conversation, error) {
-	var convs []models.Conversation
+func (r *conversationRepository) GetMember(conversationID uint, userID string) (*models.ConversationMember, error) {
+	var m models.ConversationMember
+	err := r.db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&m).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMemberNotFound
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *conversationRepository) UpdateMemberRole(tx *gorm.DB, conversationID uint, userID string, role string) error {
+	res := tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Update("role", role)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+func (r *conversationRepository) RemoveMember(tx *gorm.DB, conversationID uint, userID string) error {
+	res := tx.Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Delete(&models.ConversationMember{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+func (r *conversationRepository) ListMembers(conversationID uint) ([]MemberProfile, error) {
+	return r.listMembers([]uint{conversationID})
+}
+
+func (r *conversationRepository) listMembers(conversationIDs []uint) ([]MemberProfile, error) {
+	var members []MemberProfile
+	err := r.db.
+		Table("conversation_members cm").
+		Select("cm.conversation_id, cm.user_id, u.username, u.avatar_url, cm.role, cm.last_delivered_message_id, cm.last_read_message_id, cm.last_read_at, cm.created_at").
+		Joins("JOIN users u ON u.id = cm.user_id").
+		Where("cm.conversation_id IN ?", conversationIDs).
+		Order("cm.created_at ASC, cm.id ASC").
+		Scan(&members).Error
+	return members, err
+}
+
+// AdvanceDeliveredCursor moves the member's delivered cursor forward to
+// messageID. It reports false when the cursor was already at or past it.
+func (r *conversationRepository) AdvanceDeliveredCursor(conversationID uint, userID string, messageID uint) (bool, error) {
+	res := r.db.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Where("last_delivered_message_id IS NULL OR last_delivered_message_id < ?", messageID).
+		Update("last_delivered_message_id", messageID)
+	return res.RowsAffected > 0, res.Error
+}
+
+// AdvanceReadCursor moves the member's read cursor forward to messageID. A
+// read message is also delivered, so the delivered cursor follows along.
+func (r *conversationRepository) AdvanceReadCursor(conversationID uint, userID string, messageID uint, when time.Time) (bool, error) {
+	res := r.db.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Where("last_read_message_id IS NULL OR last_read_message_id < ?", messageID).
+		Updates(map[string]any{
+			"last_read_message_id":      messageID,
+			"last_read_at":              when,
+			"last_delivered_message_id": gorm.Expr("GREATEST(COALESCE(last_delivered_message_id, 0), ?)", messageID),
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *conversationRepository) Touch(tx *gorm.DB, conversationID uint, when time.Time) error {
+	return tx.Model(&models.Conversation{}).
+		Where("id = ?", conversationID).
+		UpdateColumn("updated_at", when).Error
+}
+
+// ListUserConversations returns the user's conversations by latest activity.
+// Last message and unread count come from the same query; members are loaded
+// with a single extra query for all conversations.
+func (r *conversationRepository) ListUserConversations(userID string) ([]ConversationSummary, error) {
+	var convs []ConversationSummary
+	err := r.db.Raw(`
+		SELECT c.id, c.is_group, c.title, c.created_at, c.updated_at,
+			lm.id AS last_message_id,
+			lm.sender_id AS last_message_sender_id,
+			u.username AS last_message_sender_name,
+			lm.content AS last_message_content,
+			lm.sent_at AS last_message_sent_at,
+			lm.deleted_at AS last_message_deleted_at,
+			(
+				SELECT COUNT(*) FROM messages m
+				WHERE m.conversation_id = c.id
+					AND m.id > COALESCE(me.last_read_message_id, 0)
+					AND m.sender_id <> me.user_id
+					AND m.deleted_at IS NULL
+					AND m.thread_root_id IS NULL
+					AND NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = me.user_id)
+			) AS unread_count
+		FROM conversations c
+		JOIN conversation_members me ON me.conversation_id = c.id AND me.user_id = ?
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.sender_id, m.content, m.sent_at, m.deleted_at
+			FROM messages m
+			WHERE m.conversation_id = c.id
+				AND m.thread_root_id IS NULL
+				AND NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = me.user_id)
+			ORDER BY m.id DESC
+			LIMIT 1
+		) lm ON true
+		LEFT JOIN users u ON u.id = lm.sender_id
+		ORDER BY c.updated_at DESC, c.id DESC`, userID).
+		Scan(&convs).Error
+	if err != nil || len(convs) == 0 {
+		return convs, err
+	}
+
+	ids := make([]uint, len(convs))
+	index := make(map[uint]int, len(convs))
+	for i, c := range convs {
+		ids[i] = c.ID
+		index[c.ID] = i
+	}
+
+	members, err := r.listMembers(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		i := index[m.ConversationID]
+		convs[i].Members = append(convs[i].Members, m)
+	}
+	return convs, nil
+}
+
+func (r *conversationRepository) ListUserConversationIDs(userID string) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.ConversationMember{}).
+		Where("user_id = ?", userID).
+		Pluck("conversation_id", &ids).Error
+	return ids, err
+}
+
+// ListContactIDs returns every other user sharing at least one conversation
+// with userID.
+func (r *conversationRepository) ListContactIDs(userID string) ([]string, error) {
+	var ids []string
 	err := r.db.
-		Joins("JOIN conversation_members cm ON cm.conversation_id = conversations.id").
-		Where("cm.user_id = ?", userID).
-		Order("conversations.updated_at DESC").
-		Find(&convs).Error
-	return convs, err
+		Table("conversation_members me").
+		Joins("JOIN conversation_members other ON other.conversation_id = me.conversation_id").
+		Where("me.user_id = ? AND other.user_id <> ?", userID, userID).
+		Distinct().
+		Pluck("other.user_id", &ids).Error
+	return ids, err
 }
 
 func (r *conversationRepository) FindDirectConversation(userA string, userB string) (*models.Conversation, error) {
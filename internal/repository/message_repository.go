@@ -1,14 +1,72 @@
 package repository
 
 import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
 	"talk-backend/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrMessageNotFound = errors.New("message not found")
+var ErrDuplicateMessage = errors.New("message already stored")
+
+// SearchFilter narrows a full-text search. Query uses web search syntax:
+// quoted phrases, OR, and -word exclusions.
+type SearchFilter struct {
+	Query          string
+	ConversationID *uint
+	SenderID       *string
+	From           *time.Time
+	To             *time.Time
+	Limit          int
+	BeforeID       *uint
+}
+
+type SearchHit struct {
+	MessageID      uint
+	ConversationID uint
+	SenderID       string
+	SentAt         time.Time
+	// Snippet is HTML: the message text escaped, with matches wrapped in
+	// <mark> tags. It is safe to render as is.
+	Snippet string
+}
+
+// Matches are delimited with private-use characters, which are stripped from
+// the content first, so the snippet can be escaped before the markers become
+// tags.
+const (
+	markStart = "\ue000"
+	markStop  = "\ue001"
+)
+
+var headlineOptions = `StartSel="` + markStart + `", StopSel="` + markStop + `", MaxWords=30, MinWords=10, MaxFragments=2`
+
+var markReplacer = strings.NewReplacer(markStart, "<mark>", markStop, "</mark>")
+
+// highlight turns a ts_headline snippet into safe HTML.
+func highlight(snippet string) string {
+	return markReplacer.Replace(html.EscapeString(snippet))
+}
+
 type MessageRepository interface {
-	Create(msg *models.Message) error
-	List(conversationID uint, limit int, beforeID *uint) ([]models.Message, error)
+	Create(tx *gorm.DB, msg *models.Message) error
+	FindByID(id uint) (*models.Message, error)
+	UpdateContent(msg *models.Message, content string, editedBy string, when time.Time) error
+	SoftDelete(msg *models.Message, deletedBy string, when time.Time) ([]string, error)
+	HideForUser(messageID uint, userID string) error
+	AddReaction(messageID uint, userID string, emoji string) (bool, error)
+	RemoveReaction(messageID uint, userID string, emoji string) (bool, error)
+	List(conversationID uint, viewerID string, limit int, beforeID *uint) ([]models.Message, error)
+	ListAfter(conversationID uint, viewerID string, afterID uint, limit int) ([]models.Message, error)
+	ListAfterForMember(userID string, afterID uint, limit int) ([]models.Message, error)
+	ListThread(rootID uint, viewerID string, limit int, beforeID *uint) ([]models.Message, error)
+	Search(viewerID string, f SearchFilter) ([]SearchHit, error)
 }
 
 type messageRepository struct{ db *gorm.DB }
@@ -17,21 +75,313 @@ func NewMessageRepository(db *gorm.DB) MessageRepository {
 	return &messageRepository{db: db}
 }
 
-func (r *messageRepository) Create(msg *models.Message) error {
-	return r.db.Create(msg).Error
+// Create inserts msg. If the sender already stored a message with the same
+// ClientMessageID, msg is replaced by that message and ErrDuplicateMessage is
+// returned.
+func (r *messageRepository) Create(tx *gorm.DB, msg *models.Message) error {
+	if msg.ClientMessageID == nil {
+		return tx.Create(msg).Error
+	}
+
+	res := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sender_id"}, {Name: "client_message_id"}},
+		DoNothing: true,
+	}).Create(msg)
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error
+	}
+
+	var existing models.Message
+	err := tx.
+		Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Where("sender_id = ? AND client_message_id = ?", msg.SenderID, *msg.ClientMessageID).
+		First(&existing).Error
+	if err != nil {
+		return err
+	}
+	*msg = existing
+	return ErrDuplicateMessage
+}
+
+func (r *messageRepository) FindByID(id uint) (*models.Message, error) {
+	var msg models.Message
+	err := r.db.Where("id = ?", id).First(&msg).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// UpdateContent archives the current content in message_edits and replaces it.
+func (r *messageRepository) UpdateContent(msg *models.Message, content string, editedBy string, when time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		edit := &models.MessageEdit{
+			MessageID: msg.ID,
+			Content:   msg.Content,
+			EditedBy:  editedBy,
+			EditedAt:  when,
+		}
+		if err := tx.Create(edit).Error; err != nil {
+			return err
+		}
+
+		err := tx.Model(msg).Updates(map[string]any{
+			"content":   content,
+			"edited_at": when,
+		}).Error
+		if err != nil {
+			return err
+		}
+		msg.Content = content
+		msg.EditedAt = &when
+		return nil
+	})
+}
+
+// SoftDelete turns the message into a tombstone: the row stays so clients can
+// render a placeholder, but its content, edit history, reactions and
+// attachment records are wiped. It returns the storage keys of the removed
+// attachments so the caller can delete the objects once the change is
+// committed.
+func (r *messageRepository) SoftDelete(msg *models.Message, deletedBy string, when time.Time) ([]string, error) {
+	var keys []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&models.MessageEdit{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&models.MessageReaction{}).Error; err != nil {
+			return err
+		}
+		var removed []models.Attachment
+		err := tx.Clauses(clause.Returning{Columns: []clause.Column{{Name: "storage_key"}}}).
+			Where("message_id = ?", msg.ID).
+			Delete(&removed).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(msg).Updates(map[string]any{
+			"content":    "",
+			"deleted_at": when,
+			"deleted_by": deletedBy,
+		}).Error
+		if err != nil {
+			return err
+		}
+		for _, att := range removed {
+			keys = append(keys, att.StorageKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.Content = ""
+	msg.DeletedAt = &when
+	msg.DeletedBy = &deletedBy
+	return keys, nil
 }
 
-func (r *messageRepository) List(conversationID uint, limit int, beforeID *uint) ([]models.Message, error) {
+func (r *messageRepository) HideForUser(messageID uint, userID string) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.HiddenMessage{MessageID: messageID, UserID: userID}).Error
+}
+
+// AddReaction reports false if the user already reacted with this emoji.
+func (r *messageRepository) AddReaction(messageID uint, userID string, emoji string) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.MessageReaction{MessageID: messageID, UserID: userID, Emoji: emoji})
+	return res.RowsAffected > 0, res.Error
+}
+
+// RemoveReaction reports false if there was no such reaction.
+func (r *messageRepository) RemoveReaction(messageID uint, userID string, emoji string) (bool, error) {
+	res := r.db.Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Delete(&models.MessageReaction{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// List returns the conversation's main timeline newest first, leaving out
+// thread replies and messages viewerID hid for themselves.
+func (r *messageRepository) List(conversationID uint, viewerID string, limit int, beforeID *uint) ([]models.Message, error) {
+	q := r.db.Where("conversation_id = ? AND thread_root_id IS NULL", conversationID)
+	return r.page(q, viewerID, limit, beforeID)
+}
+
+// ListAfter returns messages newer than afterID oldest first, thread replies
+// included, so a reconnecting client can replay what it missed.
+func (r *messageRepository) ListAfter(conversationID uint, viewerID string, afterID uint, limit int) ([]models.Message, error) {
+	var msgs []models.Message
+	err := r.db.
+		Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Where("conversation_id = ? AND id > ?", conversationID, afterID).
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = messages.id AND hm.user_id = ?)", viewerID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&msgs).Error
+	return msgs, err
+}
+
+// ListAfterForMember is ListAfter across every conversation userID belongs
+// to. Message IDs are global, so one cursor covers them all.
+func (r *messageRepository) ListAfterForMember(userID string, afterID uint, limit int) ([]models.Message, error) {
+	var msgs []models.Message
+	err := r.db.
+		Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Joins("JOIN conversation_members cm ON cm.conversation_id = messages.conversation_id AND cm.user_id = ?", userID).
+		Where("messages.id > ?", afterID).
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = messages.id AND hm.user_id = ?)", userID).
+		Order("messages.id ASC").
+		Limit(limit).
+		Find(&msgs).Error
+	return msgs, err
+}
+
+// ListThread pages the replies of a thread the same way List pages a
+// conversation.
+func (r *messageRepository) ListThread(rootID uint, viewerID string, limit int, beforeID *uint) ([]models.Message, error) {
+	q := r.db.Where("thread_root_id = ?", rootID)
+	return r.page(q, viewerID, limit, beforeID)
+}
+
+// Search looks for messages in the conversations viewerID belongs to, newest
+// first. Snippets are escaped HTML with matches wrapped in <mark> tags.
+func (r *messageRepository) Search(viewerID string, f SearchFilter) ([]SearchHit, error) {
+	limit := f.Limit
 	if limit <= 0 || limit > 100 {
 		limit = 30
 	}
 
-	q := r.db.Where("conversation_id = ?", conversationID).Order("id DESC").Limit(limit)
+	q := r.db.
+		Table("messages m").
+		Select(`m.id AS message_id, m.conversation_id, m.sender_id, m.sent_at,
+			ts_headline('simple', translate(m.content, ?, ''), websearch_to_tsquery('simple', ?), ?) AS snippet`,
+			markStart+markStop, f.Query, headlineOptions).
+		Joins("JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = ?", viewerID).
+		Where("m.search_vector @@ websearch_to_tsquery('simple', ?)", f.Query).
+		Where("m.deleted_at IS NULL").
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = ?)", viewerID).
+		Order("m.id DESC").
+		Limit(limit)
+
+	if f.ConversationID != nil {
+		q = q.Where("m.conversation_id = ?", *f.ConversationID)
+	}
+	if f.SenderID != nil {
+		q = q.Where("m.sender_id = ?", *f.SenderID)
+	}
+	if f.From != nil {
+		q = q.Where("m.sent_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("m.sent_at < ?", *f.To)
+	}
+	if f.BeforeID != nil && *f.BeforeID > 0 {
+		q = q.Where("m.id < ?", *f.BeforeID)
+	}
+
+	var hits []SearchHit
+	if err := q.Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Snippet = highlight(hits[i].Snippet)
+	}
+	return hits, nil
+}
+
+func (r *messageRepository) page(q *gorm.DB, viewerID string, limit int, beforeID *uint) ([]models.Message, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+
+	q = q.
+		Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = messages.id AND hm.user_id = ?)", viewerID).
+		Order("id DESC").
+		Limit(limit)
 	if beforeID != nil && *beforeID > 0 {
 		q = q.Where("id < ?", *beforeID)
 	}
 
 	var msgs []models.Message
-	err := q.Find(&msgs).Error
-	return msgs, err
+	if err := q.Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	if err := r.attachReactions(msgs, viewerID); err != nil {
+		return nil, err
+	}
+	if err := r.attachThreadCounts(msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func (r *messageRepository) attachReactions(msgs []models.Message, viewerID string) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(msgs))
+	index := make(map[uint]int, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+		index[m.ID] = i
+	}
+
+	var rows []struct {
+		MessageID uint
+		models.ReactionCount
+	}
+	err := r.db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted_by_me", viewerID).
+		Where("message_id IN ?", ids).
+		Group("message_id, emoji").
+		Order("MIN(created_at) ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		i := index[row.MessageID]
+		msgs[i].Reactions = append(msgs[i].Reactions, row.ReactionCount)
+	}
+	return nil
+}
+
+func (r *messageRepository) attachThreadCounts(msgs []models.Message) error {
+	var ids []uint
+	index := make(map[uint]int, len(msgs))
+	for i, m := range msgs {
+		if m.ThreadRootID != nil {
+			continue
+		}
+		ids = append(ids, m.ID)
+		index[m.ID] = i
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		ThreadRootID uint
+		Count        int64
+	}
+	err := r.db.Model(&models.Message{}).
+		Select("thread_root_id, COUNT(*) AS count").
+		Where("thread_root_id IN ? AND deleted_at IS NULL", ids).
+		Group("thread_root_id").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		msgs[index[row.ThreadRootID]].ThreadReplyCount = row.Count
+	}
+	return nil
 }
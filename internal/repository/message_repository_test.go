@@ -0,0 +1,24 @@
+package repository
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+		want    string
+	}{
+		{name: "plain match", snippet: "say " + markStart + "hello" + markStop + " world", want: "say <mark>hello</mark> world"},
+		{name: "markup in content is escaped", snippet: `<img src=x onerror="alert(1)"> ` + markStart + "hi" + markStop, want: `&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>hi</mark>`},
+		{name: "tags next to a match", snippet: markStart + "<script>" + markStop, want: "<mark>&lt;script&gt;</mark>"},
+		{name: "entities are not decoded", snippet: "&lt;b&gt; & '", want: "&amp;lt;b&amp;gt; &amp; &#39;"},
+		{name: "no match", snippet: "nothing here", want: "nothing here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.snippet); got != tt.want {
+				t.Fatalf("highlight = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
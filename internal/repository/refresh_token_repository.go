@@ -7,14 +7,23 @@ import (
 	"talk-backend/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+var ErrRefreshTokenRevoked = errors.New("refresh token already revoked")
 
 type RefreshTokenRepository interface {
 	Create(rt *models.RefreshToken) error
+	FindByHash(hash string) (*models.RefreshToken, error)
 	FindValidByHash(hash string) (*models.RefreshToken, error)
+	Rotate(old, next *models.RefreshToken, when time.Time) error
 	Revoke(rt *models.RefreshToken, when time.Time) error
+	RevokeFamily(userID, familyID string, when time.Time) (int64, error)
+	RevokeOtherFamilies(userID, keepFamilyID string, when time.Time) ([]string, error)
+	RevokeAll(userID string, when time.Time) error
+	IsFamilyActive(userID, familyID string) (bool, error)
+	ListActive(userID string) ([]models.RefreshToken, error)
 	Update(rt *models.RefreshToken) error
 }
 
@@ -28,6 +37,20 @@ func (r *refreshTokenRepository) Create(rt *models.RefreshToken) error {
 	return r.db.Create(rt).Error
 }
 
+// FindByHash returns the token whatever its state, so callers can tell a
+// replayed token from an unknown one.
+func (r *refreshTokenRepository) FindByHash(hash string) (*models.RefreshToken, error) {
+	var rt models.RefreshToken
+	err := r.db.Preload("User").Where("token_hash = ?", hash).First(&rt).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, err
+	}
+	return &rt, nil
+}
+
 func (r *refreshTokenRepository) FindValidByHash(hash string) (*models.RefreshToken, error) {
 	var rt models.RefreshToken
 	err := r.db.Preload("User").
@@ -47,6 +70,85 @@ func (r *refreshTokenRepository) Revoke(rt *models.RefreshToken, when time.Time)
 	return r.db.Save(rt).Error
 }
 
+// Rotate stores next and revokes old in its favour, moving old into next's
+// family (tokens from before families existed have none). The revocation
+// only applies if old is still live, so of two concurrent rotations of the
+// same token one fails with ErrRefreshTokenRevoked.
+func (r *refreshTokenRepository) Rotate(old, next *models.RefreshToken, when time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", old.ID).
+			Updates(map[string]any{"revoked_at": when, "replaced_by_id": next.ID, "family_id": next.FamilyID})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrRefreshTokenRevoked
+		}
+		old.RevokedAt = &when
+		old.ReplacedByID = &next.ID
+		old.FamilyID = next.FamilyID
+		return nil
+	})
+}
+
+// RevokeFamily revokes every live token of the user's rotation chain and
+// returns how many were still live.
+func (r *refreshTokenRepository) RevokeFamily(userID, familyID string, when time.Time) (int64, error) {
+	res := r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userID, familyID).
+		Update("revoked_at", when)
+	return res.RowsAffected, res.Error
+}
+
+// RevokeOtherFamilies revokes every live token of the user outside
+// keepFamilyID and returns the families that were affected.
+func (r *refreshTokenRepository) RevokeOtherFamilies(userID, keepFamilyID string, when time.Time) ([]string, error) {
+	var revoked []models.RefreshToken
+	err := r.db.Model(&revoked).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "family_id"}}}).
+		Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, keepFamilyID).
+		Update("revoked_at", when).Error
+	if err != nil {
+		return nil, err
+	}
+	families := make([]string, 0, len(revoked))
+	for _, rt := range revoked {
+		families = append(families, rt.FamilyID)
+	}
+	return families, nil
+}
+
+func (r *refreshTokenRepository) RevokeAll(userID string, when time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", when).Error
+}
+
+// IsFamilyActive reports whether the family still has a token that was not
+// revoked, i.e. whether the session is still signed in.
+func (r *refreshTokenRepository) IsFamilyActive(userID, familyID string) (bool, error) {
+	var n int64
+	err := r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userID, familyID).
+		Count(&n).Error
+	return n > 0, err
+}
+
+// ListActive returns the live token of each of the user's sessions, most
+// recently used first.
+func (r *refreshTokenRepository) ListActive(userID string) ([]models.RefreshToken, error) {
+	var tokens []models.RefreshToken
+	err := r.db.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > NOW()", userID).
+		Order("last_used_at DESC NULLS LAST, id DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
 func (r *refreshTokenRepository) Update(rt *models.RefreshToken) error {
 	return r.db.Save(rt).Error
 }
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"time"
+
+	"talk-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// signingKeyLock is the advisory lock taken while rotating, so replicas that
+// find the current key due at the same time create only one successor.
+const signingKeyLock = 0x6b657973
+
+// RotationFunc looks at the usable keys and returns the key to add, if any,
+// and when the keys it replaces retire.
+type RotationFunc func(usable []models.SigningKey) (next *models.SigningKey, retireAt time.Time)
+
+type SigningKeyRepository interface {
+	ListUsable(now time.Time) ([]models.SigningKey, error)
+	Rotate(now time.Time, decide RotationFunc) (*models.SigningKey, error)
+}
+
+type signingKeyRepository struct{ db *gorm.DB }
+
+func NewSigningKeyRepository(db *gorm.DB) SigningKeyRepository {
+	return &signingKeyRepository{db: db}
+}
+
+// ListUsable returns the keys that are not retired yet, oldest first.
+func (r *signingKeyRepository) ListUsable(now time.Time) ([]models.SigningKey, error) {
+	return listUsableKeys(r.db, now)
+}
+
+// Rotate runs decide on the usable keys under a lock held across replicas.
+// When decide returns a key, it is saved and the keys that had no retirement
+// time get the one decide returned. Keys past their retirement are deleted.
+// It returns the saved key, or nil.
+func (r *signingKeyRepository) Rotate(now time.Time, decide RotationFunc) (*models.SigningKey, error) {
+	var next *models.SigningKey
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", signingKeyLock).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("retires_at <= ?", now).Delete(&models.SigningKey{}).Error; err != nil {
+			return err
+		}
+
+		usable, err := listUsableKeys(tx, now)
+		if err != nil {
+			return err
+		}
+		var retireAt time.Time
+		if next, retireAt = decide(usable); next == nil {
+			return nil
+		}
+
+		if err := tx.Model(&models.SigningKey{}).
+			Where("retires_at IS NULL").
+			Update("retires_at", retireAt).Error; err != nil {
+			return err
+		}
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func listUsableKeys(db *gorm.DB, now time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := db.
+		Where("retires_at IS NULL OR retires_at > ?", now).
+		Order("activates_at ASC, id ASC").
+		Find(&keys).Error
+	return keys, err
+}
@@ -3,6 +3,8 @@ package repository
 import (
 	"errors"
 	"strings"
+	"time"
+
 	"talk-backend/internal/models"
 
 	"github.com/jackc/pgx/v5/pgconn"
@@ -17,6 +19,9 @@ type UserRepository interface {
 	FindByID(id string) (*models.User, error)
 	FindByEmail(email string) (*models.User, error)
 	Update(user *models.User) error
+	FindByIDs(ids []string) ([]models.User, error)
+	UpdateLastSeen(id string, when time.Time) error
+	BumpTokenVersion(id string) error
 }
 
 type userRepository struct{ db *gorm.DB }
@@ -65,6 +70,23 @@ func (r *userRepository) FindByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
+// Update saves user. TokenVersion is left out so that saving a stale copy
+// cannot undo a concurrent BumpTokenVersion.
 func (r *userRepository) Update(user *models.User) error {
-	return r.db.Save(user).Error
+	return r.db.Omit("token_version").Save(user).Error
+}
+
+func (r *userRepository) FindByIDs(ids []string) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+func (r *userRepository) UpdateLastSeen(id string, when time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).UpdateColumn("last_seen_at", when).Error
+}
+
+func (r *userRepository) BumpTokenVersion(id string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
 }
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"talk-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTicketNotFound = errors.New("ticket not found")
+
+type WSTicketRepository interface {
+	Create(t *models.WSTicket) error
+	Consume(hash string, now time.Time) (*models.WSTicket, error)
+	DeleteExpired(now time.Time) error
+}
+
+type wsTicketRepository struct{ db *gorm.DB }
+
+func NewWSTicketRepository(db *gorm.DB) WSTicketRepository {
+	return &wsTicketRepository{db: db}
+}
+
+func (r *wsTicketRepository) Create(t *models.WSTicket) error {
+	return r.db.Create(t).Error
+}
+
+// Consume deletes and returns the ticket in one statement, so two handshakes
+// racing with the same ticket cannot both succeed.
+func (r *wsTicketRepository) Consume(hash string, now time.Time) (*models.WSTicket, error) {
+	var tickets []models.WSTicket
+	err := r.db.Clauses(clause.Returning{}).
+		Where("token_hash = ? AND expires_at > ?", hash, now).
+		Delete(&tickets).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(tickets) == 0 {
+		return nil, ErrTicketNotFound
+	}
+	return &tickets[0], nil
+}
+
+func (r *wsTicketRepository) DeleteExpired(now time.Time) error {
+	return r.db.Where("expires_at <= ?", now).Delete(&models.WSTicket{}).Error
+}
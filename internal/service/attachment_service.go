@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"talk-backend/internal/models"
+	"talk-backend/internal/repository"
+	"talk-backend/internal/storage"
+)
+
+var ErrFileTooLarge = errors.New("file too large")
+var ErrFileTypeNotAllowed = errors.New("file type not allowed")
+
+type AttachmentConfig struct {
+	MaxSize      int64
+	AllowedTypes []string
+	// PendingTTL is how long an upload may wait to be sent in a message
+	// before it is deleted.
+	PendingTTL time.Duration
+}
+
+type AttachmentService struct {
+	convs       repository.ConversationRepository
+	attachments repository.AttachmentRepository
+	store       storage.Storage
+	cfg         AttachmentConfig
+}
+
+func NewAttachmentService(
+	convs repository.ConversationRepository,
+	attachments repository.AttachmentRepository,
+	store storage.Storage,
+	cfg AttachmentConfig,
+) *AttachmentService {
+	return &AttachmentService{convs: convs, attachments: attachments, store: store, cfg: cfg}
+}
+
+// Upload stores a file for a conversation. The MIME type is sniffed from the
+// content rather than trusted from the client.
+func (s *AttachmentService) Upload(ctx context.Context, me string, conversationID uint, fileName string, r io.Reader, size int64) (*models.Attachment, error) {
+	ok, err := s.convs.IsMember(conversationID, me)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	if size <= 0 || size > s.cfg.MaxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !s.allowed(mimeType) {
+		return nil, ErrFileTypeNotAllowed
+	}
+
+	suffix, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("conversations/%d/%s%s", conversationID, suffix, strings.ToLower(filepath.Ext(fileName)))
+
+	hash := sha256.New()
+	body := io.TeeReader(io.LimitReader(io.MultiReader(bytes.NewReader(head), r), size), hash)
+	if err := s.store.Put(ctx, key, body, size, mimeType); err != nil {
+		return nil, err
+	}
+
+	att := &models.Attachment{
+		ConversationID: conversationID,
+		UploaderID:     me,
+		FileName:       filepath.Base(fileName),
+		MimeType:       mimeType,
+		Size:           size,
+		Checksum:       hex.EncodeToString(hash.Sum(nil)),
+		StorageKey:     key,
+	}
+	if err := s.attachments.Create(att); err != nil {
+		_ = s.store.Delete(ctx, key)
+		return nil, err
+	}
+
+	// Sent attachments are deleted with their message; this sweeps the
+	// uploads that were never sent.
+	keys, err := s.attachments.DeletePendingBefore(time.Now().Add(-s.cfg.PendingTTL))
+	if err != nil {
+		log.Printf("[ATTACHMENT] cannot delete stale uploads: %v", err)
+	}
+	deleteObjects(ctx, s.store, keys)
+	return att, nil
+}
+
+// Open returns an attachment and its content. Members of the conversation can
+// read attachments sent in it; pending uploads are visible to the uploader only.
+func (s *AttachmentService) Open(ctx context.Context, me string, id uint) (*models.Attachment, io.ReadCloser, error) {
+	att, err := s.attachments.FindByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ok, err := s.convs.IsMember(att.ConversationID, me)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok || (att.MessageID == nil && att.UploaderID != me) {
+		return nil, nil, ErrForbidden
+	}
+
+	rc, err := s.store.Get(ctx, att.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return att, rc, nil
+}
+
+func (s *AttachmentService) allowed(mimeType string) bool {
+	for _, t := range s.cfg.AllowedTypes {
+		if t == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteObjects removes stored files whose records are gone. A failure only
+// leaves an unreachable object behind, so it is logged rather than returned.
+func deleteObjects(ctx context.Context, store storage.Storage, keys []string) {
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			log.Printf("[ATTACHMENT] cannot delete object %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"talk-backend/internal/models"
+)
+
+func TestUploadSweepsStalePendingUploads(t *testing.T) {
+	sent := uint(9)
+	tests := []struct {
+		name      string
+		messageID *uint
+		age       time.Duration
+		kept      bool
+	}{
+		{name: "stale pending upload", age: 2 * time.Hour, kept: false},
+		{name: "recent pending upload", age: time.Minute, kept: true},
+		{name: "stale sent attachment", messageID: &sent, age: 2 * time.Hour, kept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemStore()
+			attachments := newMemAttachments()
+			members := &memMembers{members: map[uint][]string{1: {aliceID}}}
+			svc := NewAttachmentService(members, attachments, store, AttachmentConfig{
+				MaxSize:      1 << 20,
+				AllowedTypes: []string{"text/plain"},
+				PendingTTL:   time.Hour,
+			})
+
+			old := &models.Attachment{
+				ConversationID: 1,
+				MessageID:      tt.messageID,
+				UploaderID:     aliceID,
+				StorageKey:     "conversations/1/old.txt",
+				CreatedAt:      time.Now().Add(-tt.age),
+			}
+			store.Put(ctx, old.StorageKey, strings.NewReader("old"), 3, "text/plain")
+			attachments.Create(old)
+
+			att, err := svc.Upload(ctx, aliceID, 1, "new.txt", strings.NewReader("hello"), 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !store.has(att.StorageKey) {
+				t.Fatal("new upload was swept")
+			}
+			if _, err := attachments.FindByID(old.ID); (err == nil) != tt.kept {
+				t.Errorf("record kept = %v, want %v", err == nil, tt.kept)
+			}
+			if got := store.has(old.StorageKey); got != tt.kept {
+				t.Errorf("object kept = %v, want %v", got, tt.kept)
+			}
+		})
+	}
+}
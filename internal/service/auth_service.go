@@ -5,8 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"log"
 	"time"
 
+	"talk-backend/internal/auth"
 	"talk-backend/internal/models"
 	"talk-backend/internal/repository"
 
@@ -17,28 +19,32 @@ import (
 var ErrInvalidCredentials = errors.New("invalid credentials")
 
 type AuthConfig struct {
-	JWTSecret      string
 	AccessTTL      time.Duration
 	RefreshTTL     time.Duration
 	MaxFailedLogin int
 	LockDuration   time.Duration
 	Issuer         string
+	Audience       string
 }
 
 type AuthService struct {
-	users  repository.UserRepository
-	tokens repository.RefreshTokenRepository
-	audit  repository.AuditRepository
-	cfg    AuthConfig
+	users    repository.UserRepository
+	tokens   repository.RefreshTokenRepository
+	audit    repository.AuditRepository
+	sessions *SessionService
+	keys     *KeyService
+	cfg      AuthConfig
 }
 
 func NewAuthService(
 	users repository.UserRepository,
 	tokens repository.RefreshTokenRepository,
 	audit repository.AuditRepository,
+	sessions *SessionService,
+	keys *KeyService,
 	cfg AuthConfig,
 ) *AuthService {
-	return &AuthService{users: users, tokens: tokens, audit: audit, cfg: cfg}
+	return &AuthService{users: users, tokens: tokens, audit: audit, sessions: sessions, keys: keys, cfg: cfg}
 }
 
 func (s *AuthService) Register(username, email, password, avatarURL string) (*models.User, error) {
@@ -57,7 +63,9 @@ func (s *AuthService) Register(username, email, password, avatarURL string) (*mo
 	return u, nil
 }
 
-func (s *AuthService) Login(email, password, ip, ua string) (accessToken string, refreshToken string, err error) {
+// Login starts a new session. deviceName is optional and names the session
+// in the sessions list; when empty one is derived from the user agent.
+func (s *AuthService) Login(email, password, deviceName, ip, ua string) (accessToken string, refreshToken string, err error) {
 	// Trouver user
 	u, findErr := s.users.FindByEmail(email)
 	if findErr != nil {
@@ -92,12 +100,18 @@ func (s *AuthService) Login(email, password, ip, ua string) (accessToken string,
 
 	s.auditLogin(&u.ID, email, ip, ua, "login_success")
 
-	accessToken, err = s.signAccessToken(u.ID)
+	if deviceName == "" {
+		deviceName = describeDevice(ua)
+	}
+	rt, refreshToken, err := s.newRefreshToken(u.ID, "", deviceName, ip, ua)
 	if err != nil {
 		return "", "", err
 	}
+	if err := s.tokens.Create(rt); err != nil {
+		return "", "", err
+	}
 
-	refreshToken, err = s.issueRefreshToken(u.ID)
+	accessToken, err = s.signAccessToken(u.ID, rt.FamilyID, u.TokenVersion)
 	if err != nil {
 		return "", "", err
 	}
@@ -105,24 +119,43 @@ func (s *AuthService) Login(email, password, ip, ua string) (accessToken string,
 	return accessToken, refreshToken, nil
 }
 
+// Refresh rotates a refresh token: the presented token is revoked and linked
+// to its successor. Presenting a token that was already rotated means it
+// leaked, either to an attacker or from a client that was itself replaced by
+// one, so the whole family is revoked and the user has to log in again.
 func (s *AuthService) Refresh(oldRefreshToken, ip, ua string) (newAccess string, newRefresh string, err error) {
 	oldHash := hashToken(oldRefreshToken)
 
-	rt, err := s.tokens.FindValidByHash(oldHash)
+	rt, err := s.tokens.FindByHash(oldHash)
 	if err != nil {
 		s.auditLogin(nil, "", ip, ua, "refresh_fail")
 		return "", "", ErrInvalidCredentials
 	}
 
 	now := time.Now()
-	_ = s.tokens.Revoke(rt, now)
+	if rt.RevokedAt != nil && rt.ReplacedByID != nil {
+		s.revokeFamily(rt, now, ip, ua)
+		return "", "", ErrInvalidCredentials
+	}
+	if rt.RevokedAt != nil || !rt.ExpiresAt.After(now) {
+		s.auditLogin(&rt.UserID, rt.User.Email, ip, ua, "refresh_fail")
+		return "", "", ErrInvalidCredentials
+	}
 
-	newAccess, err = s.signAccessToken(rt.UserID)
+	next, newRefresh, err := s.newRefreshToken(rt.UserID, rt.FamilyID, rt.DeviceName, ip, ua)
 	if err != nil {
 		return "", "", err
 	}
+	if err := s.tokens.Rotate(rt, next, now); err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenRevoked) {
+			// Another request rotated it first.
+			s.revokeFamily(rt, now, ip, ua)
+			return "", "", ErrInvalidCredentials
+		}
+		return "", "", err
+	}
 
-	newRefresh, err = s.issueRefreshToken(rt.UserID)
+	newAccess, err = s.signAccessToken(rt.UserID, next.FamilyID, rt.User.TokenVersion)
 	if err != nil {
 		return "", "", err
 	}
@@ -131,6 +164,14 @@ func (s *AuthService) Refresh(oldRefreshToken, ip, ua string) (newAccess string,
 	return newAccess, newRefresh, nil
 }
 
+func (s *AuthService) revokeFamily(rt *models.RefreshToken, now time.Time, ip, ua string) {
+	if _, err := s.tokens.RevokeFamily(rt.UserID, rt.FamilyID, now); err != nil {
+		log.Printf("[AUTH] cannot revoke token family of %s: %v", rt.UserID, err)
+	}
+	s.sessions.ended(rt.UserID, rt.FamilyID)
+	s.auditLogin(&rt.UserID, rt.User.Email, ip, ua, "refresh_reuse_detected")
+}
+
 func (s *AuthService) Logout(refreshToken, ip, ua string) error {
 	h := hashToken(refreshToken)
 	rt, err := s.tokens.FindValidByHash(h)
@@ -139,35 +180,65 @@ func (s *AuthService) Logout(refreshToken, ip, ua string) error {
 	}
 	now := time.Now()
 	_ = s.tokens.Revoke(rt, now)
+	s.sessions.ended(rt.UserID, rt.FamilyID)
 	s.auditLogin(&rt.UserID, rt.User.Email, ip, ua, "logout")
 	return nil
 }
 
-func (s *AuthService) signAccessToken(userID string) (string, error) {
-	claims := jwt.MapClaims{
-		"sub": userID,
-		"iss": s.cfg.Issuer,
-		"exp": time.Now().Add(s.cfg.AccessTTL).Unix(),
-		"iat": time.Now().Unix(),
+// LogoutAll invalidates every access and refresh token of the user.
+func (s *AuthService) LogoutAll(userID, ip, ua string) error {
+	return s.sessions.RevokeAll(userID, ip, ua)
+}
+
+// signAccessToken issues an access token for the session sessionID, the family
+// of the refresh token it was obtained with. version is the user's token
+// version, checked by TokenGuard on every use.
+func (s *AuthService) signAccessToken(userID, sessionID string, version int) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   userID,
+			Issuer:    s.cfg.Issuer,
+			Audience:  jwt.ClaimStrings{s.cfg.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.AccessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		SessionID: sessionID,
+		Version:   version,
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString([]byte(s.cfg.JWTSecret))
+	return s.keys.Sign(claims)
 }
 
-func (s *AuthService) issueRefreshToken(userID string) (string, error) {
+// newRefreshToken builds an unsaved token in familyID, or in a new family
+// when familyID is empty, used now from ip with user agent ua.
+func (s *AuthService) newRefreshToken(userID, familyID, deviceName, ip, ua string) (*models.RefreshToken, string, error) {
+	if familyID == "" {
+		var err error
+		if familyID, err = randomToken(16); err != nil {
+			return nil, "", err
+		}
+	}
 	raw, err := randomToken(32)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
+	now := time.Now()
 	rt := &models.RefreshToken{
-		UserID:    userID,
-		TokenHash: hashToken(raw),
-		ExpiresAt: time.Now().Add(s.cfg.RefreshTTL),
-	}
-	if err := s.tokens.Create(rt); err != nil {
-		return "", err
+		UserID:     userID,
+		TokenHash:  hashToken(raw),
+		FamilyID:   familyID,
+		ExpiresAt:  now.Add(s.cfg.RefreshTTL),
+		IP:         ip,
+		UserAgent:  truncate(ua, 512),
+		DeviceName: truncate(deviceName, 100),
+		LastUsedAt: &now,
 	}
-	return raw, nil
+	return rt, raw, nil
 }
 
 func (s *AuthService) auditLogin(userID *string, email, ip, ua, event string) {
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"talk-backend/internal/auth"
+	"talk-backend/internal/models"
+)
+
+type authEnv struct {
+	svc    *AuthService
+	tokens *memTokens
+	audit  *memAudit
+	closer *recordingCloser
+	keys   *KeyService
+}
+
+func newAuthEnv(t *testing.T) *authEnv {
+	t.Helper()
+	users := newMemUsers(models.User{ID: aliceID, Email: "alice@example.com"})
+	tokens := newMemTokens(users)
+	audit := &memAudit{}
+	closer := &recordingCloser{}
+	guard := NewTokenGuard(users, tokens, TokenGuardConfig{CacheTTL: time.Minute})
+	sessions := NewSessionService(users, tokens, audit, guard, closer)
+	keys, err := NewKeyService(&memSigningKeys{}, testKeyConfig("EdDSA", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewAuthService(users, tokens, audit, sessions, keys, AuthConfig{
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: time.Hour,
+		Issuer:     "talk",
+		Audience:   "talk-api",
+	})
+	return &authEnv{svc: svc, tokens: tokens, audit: audit, closer: closer, keys: keys}
+}
+
+func testKeyConfig(alg, secret string) KeyConfig {
+	return KeyConfig{
+		Algorithm:  alg,
+		Secret:     secret,
+		Rotation:   24 * time.Hour,
+		Prepublish: time.Hour,
+		Grace:      2 * time.Hour,
+		Refresh:    time.Minute,
+	}
+}
+
+// session stores a refresh token for alice in family and returns it.
+func (e *authEnv) session(t *testing.T, family string, expires time.Duration) string {
+	t.Helper()
+	rt, raw, err := e.svc.newRefreshToken(aliceID, family, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.ExpiresAt = time.Now().Add(expires)
+	if err := e.tokens.Create(rt); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestRefresh(t *testing.T) {
+	const family = "fam"
+	tests := []struct {
+		name string
+		// act presents tokens derived from raw and returns the error of the
+		// last refresh.
+		act           func(t *testing.T, e *authEnv, raw string) error
+		expired       bool
+		wantErr       error
+		familyRevoked bool
+		event         string
+	}{
+		{
+			name: "fresh token rotates",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				_, _, err := e.svc.Refresh(raw, "", "")
+				return err
+			},
+			event: "refresh",
+		},
+		{
+			name: "successor keeps rotating",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				_, next, err := e.svc.Refresh(raw, "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _, err = e.svc.Refresh(next, "", "")
+				return err
+			},
+			event: "refresh",
+		},
+		{
+			name: "reused token revokes the family",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				if _, _, err := e.svc.Refresh(raw, "", ""); err != nil {
+					t.Fatal(err)
+				}
+				_, _, err := e.svc.Refresh(raw, "", "")
+				return err
+			},
+			wantErr:       ErrInvalidCredentials,
+			familyRevoked: true,
+			event:         "refresh_reuse_detected",
+		},
+		{
+			name: "successor of a reused token is dead",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				_, next, err := e.svc.Refresh(raw, "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				e.svc.Refresh(raw, "", "")
+				_, _, err = e.svc.Refresh(next, "", "")
+				return err
+			},
+			wantErr:       ErrInvalidCredentials,
+			familyRevoked: true,
+			event:         "refresh_reuse_detected",
+		},
+		{
+			name: "losing a rotation race revokes the family",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				e.tokens.beforeRotate = func() {
+					if _, _, err := e.svc.Refresh(raw, "", ""); err != nil {
+						t.Fatal(err)
+					}
+				}
+				_, _, err := e.svc.Refresh(raw, "", "")
+				return err
+			},
+			wantErr:       ErrInvalidCredentials,
+			familyRevoked: true,
+			event:         "refresh_reuse_detected",
+		},
+		{
+			name: "logged out token is refused",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				if err := e.svc.Logout(raw, "", ""); err != nil {
+					t.Fatal(err)
+				}
+				_, _, err := e.svc.Refresh(raw, "", "")
+				return err
+			},
+			wantErr:       ErrInvalidCredentials,
+			familyRevoked: true,
+			event:         "refresh_fail",
+		},
+		{
+			name: "expired token is refused",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				_, _, err := e.svc.Refresh(raw, "", "")
+				return err
+			},
+			expired: true,
+			wantErr: ErrInvalidCredentials,
+			event:   "refresh_fail",
+		},
+		{
+			name: "unknown token is refused",
+			act: func(t *testing.T, e *authEnv, raw string) error {
+				_, _, err := e.svc.Refresh(raw+"x", "", "")
+				return err
+			},
+			wantErr: ErrInvalidCredentials,
+			event:   "refresh_fail",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAuthEnv(t)
+			expires := time.Hour
+			if tt.expired {
+				expires = -time.Minute
+			}
+			raw := e.session(t, family, expires)
+			other := e.session(t, "other", time.Hour)
+
+			if err := tt.act(t, e, raw); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			revoked := !slices.ContainsFunc(e.tokens.tokens, func(rt *models.RefreshToken) bool {
+				return rt.FamilyID == family && rt.RevokedAt == nil
+			})
+			if revoked != tt.familyRevoked {
+				t.Errorf("family revoked = %v, want %v", revoked, tt.familyRevoked)
+			}
+			if !e.audit.has(tt.event) {
+				t.Errorf("audit %v lacks %q", e.audit.events, tt.event)
+			}
+			if tt.event == "refresh_reuse_detected" && !slices.Contains(e.closer.sessions, family) {
+				t.Errorf("sockets of the family not closed: %v", e.closer.sessions)
+			}
+			if _, _, err := e.svc.Refresh(other, "", ""); err != nil {
+				t.Errorf("other session broken: %v", err)
+			}
+		})
+	}
+}
+
+func TestRefreshIssuesTokenForTheSession(t *testing.T) {
+	e := newAuthEnv(t)
+	raw := e.session(t, "fam", time.Hour)
+	access, _, err := e.svc.Refresh(raw, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := auth.NewVerifier(e.keys.Keyfunc, auth.Config{Issuer: "talk", Audience: "talk-api"})
+	p, err := verifier.Verify(access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != aliceID || p.SessionID != "fam" {
+		t.Errorf("principal = %+v, want alice in session fam", p)
+	}
+}
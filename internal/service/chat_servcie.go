@@ -1,26 +1,88 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"talk-backend/internal/models"
 	"talk-backend/internal/repository"
+	"talk-backend/internal/storage"
 
 	"gorm.io/gorm"
 )
 
 var ErrForbidden = errors.New("forbidden")
 var ErrNotFound = errors.New("not found")
+var ErrNotGroup = errors.New("conversation is not a group")
+var ErrInvalidRole = errors.New("invalid role")
+var ErrEditWindowExpired = errors.New("edit window expired")
+var ErrInvalidScope = errors.New("invalid scope")
+var ErrInvalidEmoji = errors.New("invalid emoji")
+var ErrInvalidReference = errors.New("referenced message is not in this conversation")
+var ErrEmptyMessage = errors.New("message has no content")
+var ErrClientIDReused = errors.New("client message ID already used in another conversation")
+var ErrUnknownUser = errors.New("unknown user")
+
+const maxEmojiLen = 64
+
+const (
+	DeleteForMe       = "me"
+	DeleteForEveryone = "everyone"
+)
+
+// NewMessage is the content of a message being sent. ReplyToID quotes another
+// message inline, ThreadRootID posts the message in that message's thread and
+// AttachmentIDs references files uploaded beforehand.
+type NewMessage struct {
+	Content       string
+	ReplyToID     *uint
+	ThreadRootID  *uint
+	AttachmentIDs []uint
+
+	// ClientMessageID makes the send idempotent: retrying with the same key
+	// returns the stored message instead of posting it again.
+	ClientMessageID string
+}
+
+type ChatConfig struct {
+	EditWindow time.Duration
+}
 
 type ChatService struct {
-	db       *gorm.DB
-	convs    repository.ConversationRepository
-	messages repository.MessageRepository
+	db          *gorm.DB
+	users       repository.UserRepository
+	convs       repository.ConversationRepository
+	messages    repository.MessageRepository
+	attachments repository.AttachmentRepository
+	store       storage.Storage
+	events      EventPublisher
+	cfg         ChatConfig
 }
 
-func NewChatService(db *gorm.DB, convs repository.ConversationRepository, messages repository.MessageRepository) *ChatService {
-	return &ChatService{db: db, convs: convs, messages: messages}
+func NewChatService(
+	db *gorm.DB,
+	users repository.UserRepository,
+	convs repository.ConversationRepository,
+	messages repository.MessageRepository,
+	attachments repository.AttachmentRepository,
+	store storage.Storage,
+	events EventPublisher,
+	cfg ChatConfig,
+) *ChatService {
+	return &ChatService{
+		db:          db,
+		users:       users,
+		convs:       convs,
+		messages:    messages,
+		attachments: attachments,
+		store:       store,
+		events:      events,
+		cfg:         cfg,
+	}
 }
 
 func (s *ChatService) CreateDirectConversation(me string, other string) (*models.Conversation, error) {
@@ -35,8 +97,8 @@ func (s *ChatService) CreateDirectConversation(me string, other string) (*models
 			return err
 		}
 		members := []models.ConversationMember{
-			{ConversationID: conv.ID, UserID: me, Role: "member"},
-			{ConversationID: conv.ID, UserID: other, Role: "member"},
+			{ConversationID: conv.ID, UserID: me, Role: models.RoleMember},
+			{ConversationID: conv.ID, UserID: other, Role: models.RoleMember},
 		}
 		return s.convs.AddMembers(tx, members)
 	})
@@ -44,14 +106,314 @@ func (s *ChatService) CreateDirectConversation(me string, other string) (*models
 	if err != nil {
 		return nil, err
 	}
+	s.events.SubscribeToConversation(conv.ID, me)
+	s.events.SubscribeToConversation(conv.ID, other)
 	return conv, nil
 }
 
-func (s *ChatService) ListMyConversations(me string) ([]models.Conversation, error) {
+// CreateGroupConversation creates a group owned by me. Every member ID must
+// belong to an existing user, otherwise ErrUnknownUser is returned.
+func (s *ChatService) CreateGroupConversation(me string, title string, memberIDs []string) (*models.Conversation, error) {
+	conv := &models.Conversation{IsGroup: true, Title: &title}
+
+	var ids []string
+	members := []models.ConversationMember{{UserID: me, Role: models.RoleOwner}}
+	seen := map[string]bool{me: true}
+	for _, id := range memberIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		members = append(members, models.ConversationMember{UserID: id, Role: models.RoleMember})
+	}
+
+	if len(ids) > 0 {
+		users, err := s.users.FindByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) != len(ids) {
+			return nil, ErrUnknownUser
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.convs.CreateConversation(tx, conv); err != nil {
+			return err
+		}
+		for i := range members {
+			members[i].ConversationID = conv.ID
+		}
+		return s.convs.AddMembers(tx, members)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		s.events.SubscribeToConversation(conv.ID, m.UserID)
+	}
+	return conv, nil
+}
+
+// SetMemberRole promotes a member to admin or demotes an admin back to member.
+// Only the owner may change roles; ownership itself moves via TransferOwnership.
+func (s *ChatService) SetMemberRole(me string, conversationID uint, userID string, role string) error {
+	if role != models.RoleAdmin && role != models.RoleMember {
+		return ErrInvalidRole
+	}
+
+	actor, err := s.groupMember(conversationID, me)
+	if err != nil {
+		return err
+	}
+	if actor.Role != models.RoleOwner {
+		return ErrForbidden
+	}
+
+	target, err := s.convs.GetMember(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if target.Role == models.RoleOwner {
+		return ErrForbidden
+	}
+	if target.Role == role {
+		return nil
+	}
+
+	return s.convs.UpdateMemberRole(s.db, conversationID, userID, role)
+}
+
+// TransferOwnership hands the owner role to another member and demotes the
+// previous owner to admin.
+func (s *ChatService) TransferOwnership(me string, conversationID uint, newOwnerID string) error {
+	actor, err := s.groupMember(conversationID, me)
+	if err != nil {
+		return err
+	}
+	if actor.Role != models.RoleOwner {
+		return ErrForbidden
+	}
+	if newOwnerID == me {
+		return nil
+	}
+
+	if _, err := s.convs.GetMember(conversationID, newOwnerID); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.convs.UpdateMemberRole(tx, conversationID, newOwnerID, models.RoleOwner); err != nil {
+			return err
+		}
+		return s.convs.UpdateMemberRole(tx, conversationID, me, models.RoleAdmin)
+	})
+}
+
+func (s *ChatService) ListMembers(me string, conversationID uint) ([]repository.MemberProfile, error) {
+	ok, err := s.convs.IsMember(conversationID, me)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+	return s.convs.ListMembers(conversationID)
+}
+
+// AddMembers adds users to a group and returns the IDs that were not already
+// members. Owners and admins only. Every ID must belong to an existing user,
+// otherwise ErrUnknownUser is returned and nobody is added.
+func (s *ChatService) AddMembers(me string, conversationID uint, userIDs []string) ([]string, error) {
+	actor, err := s.groupMember(conversationID, me)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.CanManage() {
+		return nil, ErrForbidden
+	}
+
+	var ids []string
+	var members []models.ConversationMember
+	seen := map[string]bool{}
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		members = append(members, models.ConversationMember{ConversationID: conversationID, UserID: id, Role: models.RoleMember})
+	}
+
+	users, err := s.users.FindByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) != len(ids) {
+		return nil, ErrUnknownUser
+	}
+
+	added, err := s.convs.AddNewMembers(s.db, members)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range added {
+		s.events.SubscribeToConversation(conversationID, id)
+		s.events.PublishToConversation(conversationID, MemberEvent{
+			Type:           EventMemberJoined,
+			ConversationID: conversationID,
+			UserID:         id,
+			ActorID:        me,
+		})
+	}
+	return added, nil
+}
+
+// RemoveMember kicks a member out of a group. Admins may remove members, the
+// owner may also remove admins. Removing yourself is the same as leaving.
+func (s *ChatService) RemoveMember(me string, conversationID uint, userID string) error {
+	if userID == me {
+		return s.LeaveConversation(me, conversationID)
+	}
+
+	actor, err := s.groupMember(conversationID, me)
+	if err != nil {
+		return err
+	}
+	if !actor.CanManage() {
+		return ErrForbidden
+	}
+
+	target, err := s.convs.GetMember(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if target.Role == models.RoleOwner || (target.Role == models.RoleAdmin && actor.Role != models.RoleOwner) {
+		return ErrForbidden
+	}
+
+	if err := s.convs.RemoveMember(s.db, conversationID, userID); err != nil {
+		return err
+	}
+
+	s.events.PublishToConversation(conversationID, MemberEvent{
+		Type:           EventMemberRemoved,
+		ConversationID: conversationID,
+		UserID:         userID,
+		ActorID:        me,
+	})
+	s.events.UnsubscribeFromConversation(conversationID, userID)
+	return nil
+}
+
+// LeaveConversation removes the caller from a group. When the owner leaves,
+// ownership passes to the longest-standing admin, or member if there is none.
+func (s *ChatService) LeaveConversation(me string, conversationID uint) error {
+	actor, err := s.groupMember(conversationID, me)
+	if err != nil {
+		return err
+	}
+
+	var successor string
+	if actor.Role == models.RoleOwner {
+		members, err := s.convs.ListMembers(conversationID)
+		if err != nil {
+			return err
+		}
+		successor = pickSuccessor(members, me)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.convs.RemoveMember(tx, conversationID, me); err != nil {
+			return err
+		}
+		if successor == "" {
+			return nil
+		}
+		return s.convs.UpdateMemberRole(tx, conversationID, successor, models.RoleOwner)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.events.PublishToConversation(conversationID, MemberEvent{
+		Type:           EventMemberLeft,
+		ConversationID: conversationID,
+		UserID:         me,
+	})
+	s.events.UnsubscribeFromConversation(conversationID, me)
+	return nil
+}
+
+// pickSuccessor expects members ordered by join date.
+func pickSuccessor(members []repository.MemberProfile, leaving string) string {
+	var firstMember string
+	for _, m := range members {
+		if m.UserID == leaving {
+			continue
+		}
+		if m.Role == models.RoleAdmin {
+			return m.UserID
+		}
+		if firstMember == "" {
+			firstMember = m.UserID
+		}
+	}
+	return firstMember
+}
+
+// groupMember loads the caller's membership in a group conversation. Callers
+// outside the conversation get ErrForbidden, direct chats get ErrNotGroup.
+func (s *ChatService) groupMember(conversationID uint, userID string) (*models.ConversationMember, error) {
+	conv, err := s.convs.FindByID(conversationID)
+	if err != nil {
+		if errors.Is(err, repository.ErrConversationNotFound) {
+			return nil, ErrForbidden
+		}
+		return nil, err
+	}
+
+	m, err := s.convs.GetMember(conversationID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMemberNotFound) {
+			return nil, ErrForbidden
+		}
+		return nil, err
+	}
+
+	if !conv.IsGroup {
+		return nil, ErrNotGroup
+	}
+	return m, nil
+}
+
+// ConversationIDs lists the conversations a realtime connection of the user
+// should subscribe to.
+func (s *ChatService) ConversationIDs(me string) ([]uint, error) {
+	return s.convs.ListUserConversationIDs(me)
+}
+
+// CheckAccess returns ErrForbidden unless the user is a member of the
+// conversation.
+func (s *ChatService) CheckAccess(me string, conversationID uint) error {
+	ok, err := s.convs.IsMember(conversationID, me)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (s *ChatService) ListMyConversations(me string) ([]repository.ConversationSummary, error) {
 	return s.convs.ListUserConversations(me)
 }
 
-func (s *ChatService) SendMessage(me string, conversationID uint, content string) (*models.Message, error) {
+func (s *ChatService) SendMessage(me string, conversationID uint, in NewMessage) (*models.Message, error) {
 	ok, err := s.convs.IsMember(conversationID, me)
 	if err != nil {
 		return nil, err
@@ -60,15 +422,111 @@ func (s *ChatService) SendMessage(me string, conversationID uint, content string
 		return nil, ErrForbidden
 	}
 
+	attachmentIDs := uniqueIDs(in.AttachmentIDs)
+	if strings.TrimSpace(in.Content) == "" && len(attachmentIDs) == 0 {
+		return nil, ErrEmptyMessage
+	}
+
 	msg := &models.Message{
 		ConversationID: conversationID,
 		SenderID:       me,
-		Content:        content,
+		Content:        in.Content,
 		SentAt:         time.Now(),
 	}
-	if err := s.messages.Create(msg); err != nil {
+	if in.ClientMessageID != "" {
+		msg.ClientMessageID = &in.ClientMessageID
+	}
+
+	if in.ReplyToID != nil {
+		if _, err := s.messageIn(conversationID, *in.ReplyToID); err != nil {
+			return nil, err
+		}
+		msg.ReplyToID = in.ReplyToID
+	}
+
+	if in.ThreadRootID != nil {
+		root, err := s.messageIn(conversationID, *in.ThreadRootID)
+		if err != nil {
+			return nil, err
+		}
+		// Threads are one level deep: replying inside a thread targets its root.
+		rootID := root.ID
+		if root.ThreadRootID != nil {
+			rootID = *root.ThreadRootID
+		}
+		msg.ThreadRootID = &rootID
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.messages.Create(tx, msg); err != nil {
+			return err
+		}
+		if err := s.convs.Touch(tx, conversationID, msg.SentAt); err != nil {
+			return err
+		}
+		if len(attachmentIDs) == 0 {
+			return nil
+		}
+		return s.attachments.AttachToMessage(tx, attachmentIDs, me, msg)
+	})
+	if errors.Is(err, repository.ErrDuplicateMessage) {
+		// A retry: the first attempt was stored and published already.
+		if msg.ConversationID != conversationID {
+			return nil, ErrClientIDReused
+		}
+		return msg, nil
+	}
+	if err != nil {
 		return nil, err
 	}
+
+	s.events.PublishToConversation(conversationID, MessageEvent{
+		Type:           EventMessage,
+		ConversationID: conversationID,
+		Message:        NewMessagePayload(msg),
+	})
+	return msg, nil
+}
+
+// MissedMessages returns up to limit messages posted after afterID, oldest
+// first, for a client resuming after a disconnect.
+func (s *ChatService) MissedMessages(me string, conversationID uint, afterID uint, limit int) ([]models.Message, error) {
+	if err := s.CheckAccess(me, conversationID); err != nil {
+		return nil, err
+	}
+	return s.messages.ListAfter(conversationID, me, afterID, limit)
+}
+
+// AllMissedMessages is MissedMessages across all of my conversations.
+func (s *ChatService) AllMissedMessages(me string, afterID uint, limit int) ([]models.Message, error) {
+	return s.messages.ListAfterForMember(me, afterID, limit)
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	out := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// messageIn loads a message referenced by another one, making sure it belongs
+// to the same conversation.
+func (s *ChatService) messageIn(conversationID uint, messageID uint) (*models.Message, error) {
+	msg, err := s.messages.FindByID(messageID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return nil, ErrInvalidReference
+		}
+		return nil, err
+	}
+	if msg.ConversationID != conversationID {
+		return nil, ErrInvalidReference
+	}
 	return msg, nil
 }
 
@@ -80,5 +538,268 @@ func (s *ChatService) GetMessages(me string, conversationID uint, limit int, bef
 	if !ok {
 		return nil, ErrForbidden
 	}
-	return s.messages.List(conversationID, limit, beforeID)
+	return s.messages.List(conversationID, me, limit, beforeID)
+}
+
+// SearchMessages runs a full-text search restricted to the caller's
+// conversations.
+func (s *ChatService) SearchMessages(me string, f repository.SearchFilter) ([]repository.SearchHit, error) {
+	if f.ConversationID != nil {
+		ok, err := s.convs.IsMember(*f.ConversationID, me)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrForbidden
+		}
+	}
+	return s.messages.Search(me, f)
+}
+
+// GetThread returns the root message of a thread and a page of its replies.
+func (s *ChatService) GetThread(me string, conversationID uint, rootID uint, limit int, beforeID *uint) (*models.Message, []models.Message, error) {
+	ok, err := s.convs.IsMember(conversationID, me)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, ErrForbidden
+	}
+
+	root, err := s.messages.FindByID(rootID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root.ConversationID != conversationID || root.ThreadRootID != nil {
+		return nil, nil, repository.ErrMessageNotFound
+	}
+
+	replies, err := s.messages.ListThread(rootID, me, limit, beforeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, replies, nil
+}
+
+// EditMessage replaces the content of one of the caller's own messages, as
+// long as it was sent within the configured edit window.
+func (s *ChatService) EditMessage(me string, conversationID uint, messageID uint, content string) (*models.Message, error) {
+	ok, err := s.convs.IsMember(conversationID, me)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	msg, err := s.messages.FindByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ConversationID != conversationID || msg.DeletedAt != nil {
+		return nil, repository.ErrMessageNotFound
+	}
+	if msg.SenderID != me {
+		return nil, ErrForbidden
+	}
+
+	now := time.Now()
+	if s.cfg.EditWindow > 0 && now.Sub(msg.SentAt) > s.cfg.EditWindow {
+		return nil, ErrEditWindowExpired
+	}
+	if msg.Content == content {
+		return msg, nil
+	}
+
+	if err := s.messages.UpdateContent(msg, content, me, now); err != nil {
+		return nil, err
+	}
+
+	s.events.PublishToConversation(conversationID, MessageEvent{
+		Type:           EventMessageEdited,
+		ConversationID: conversationID,
+		Message:        NewMessagePayload(msg),
+	})
+	return msg, nil
+}
+
+// DeleteMessage removes a message for the caller only (DeleteForMe) or turns
+// it into a tombstone for every member (DeleteForEveryone). Deleting for
+// everyone is reserved to the sender and to group owners and admins.
+func (s *ChatService) DeleteMessage(me string, conversationID uint, messageID uint, scope string) error {
+	member, err := s.convs.GetMember(conversationID, me)
+	if err != nil {
+		if errors.Is(err, repository.ErrMemberNotFound) {
+			return ErrForbidden
+		}
+		return err
+	}
+
+	msg, err := s.messages.FindByID(messageID)
+	if err != nil {
+		return err
+	}
+	if msg.ConversationID != conversationID {
+		return repository.ErrMessageNotFound
+	}
+
+	event := MessageDeletedEvent{
+		Type:           EventMessageDeleted,
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Scope:          scope,
+		DeletedBy:      me,
+	}
+
+	switch scope {
+	case DeleteForMe:
+		if err := s.messages.HideForUser(messageID, me); err != nil {
+			return err
+		}
+		s.events.PublishToMember(conversationID, me, event)
+		return nil
+
+	case DeleteForEveryone:
+		if msg.SenderID != me && !member.CanManage() {
+			return ErrForbidden
+		}
+		if msg.DeletedAt != nil {
+			return nil
+		}
+		keys, err := s.messages.SoftDelete(msg, me, time.Now())
+		if err != nil {
+			return err
+		}
+		deleteObjects(context.Background(), s.store, keys)
+		s.events.PublishToConversation(conversationID, event)
+		return nil
+
+	default:
+		return ErrInvalidScope
+	}
+}
+
+// MarkDelivered records that every message up to messageID reached one of the
+// caller's devices.
+func (s *ChatService) MarkDelivered(me string, conversationID uint, messageID uint) error {
+	if err := s.checkReceipt(me, conversationID, messageID); err != nil {
+		return err
+	}
+
+	advanced, err := s.convs.AdvanceDeliveredCursor(conversationID, me, messageID)
+	if err != nil || !advanced {
+		return err
+	}
+
+	s.events.PublishToConversation(conversationID, ReceiptEvent{
+		Type:           EventDelivered,
+		ConversationID: conversationID,
+		UserID:         me,
+		MessageID:      messageID,
+		At:             time.Now(),
+	})
+	return nil
+}
+
+// MarkRead moves the caller's read cursor up to messageID.
+func (s *ChatService) MarkRead(me string, conversationID uint, messageID uint) error {
+	if err := s.checkReceipt(me, conversationID, messageID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	advanced, err := s.convs.AdvanceReadCursor(conversationID, me, messageID, now)
+	if err != nil || !advanced {
+		return err
+	}
+
+	s.events.PublishToConversation(conversationID, ReceiptEvent{
+		Type:           EventRead,
+		ConversationID: conversationID,
+		UserID:         me,
+		MessageID:      messageID,
+		At:             now,
+	})
+	return nil
+}
+
+func (s *ChatService) checkReceipt(me string, conversationID uint, messageID uint) error {
+	ok, err := s.convs.IsMember(conversationID, me)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+
+	msg, err := s.messages.FindByID(messageID)
+	if err != nil {
+		return err
+	}
+	if msg.ConversationID != conversationID {
+		return repository.ErrMessageNotFound
+	}
+	return nil
+}
+
+func (s *ChatService) AddReaction(me string, conversationID uint, messageID uint, emoji string) error {
+	return s.react(me, conversationID, messageID, emoji, true)
+}
+
+func (s *ChatService) RemoveReaction(me string, conversationID uint, messageID uint, emoji string) error {
+	return s.react(me, conversationID, messageID, emoji, false)
+}
+
+func (s *ChatService) react(me string, conversationID uint, messageID uint, emoji string, add bool) error {
+	if !validEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	ok, err := s.convs.IsMember(conversationID, me)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+
+	msg, err := s.messages.FindByID(messageID)
+	if err != nil {
+		return err
+	}
+	if msg.ConversationID != conversationID || msg.DeletedAt != nil {
+		return repository.ErrMessageNotFound
+	}
+
+	event := ReactionEvent{
+		Type:           EventReactionAdded,
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		UserID:         me,
+		Emoji:          emoji,
+	}
+
+	var changed bool
+	if add {
+		changed, err = s.messages.AddReaction(messageID, me, emoji)
+	} else {
+		event.Type = EventReactionRemoved
+		changed, err = s.messages.RemoveReaction(messageID, me, emoji)
+	}
+	if err != nil || !changed {
+		return err
+	}
+
+	s.events.PublishToConversation(conversationID, event)
+	return nil
+}
+
+// validEmoji accepts any short printable token; clients own the emoji set.
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLen || !utf8.ValidString(emoji) {
+		return false
+	}
+	return !strings.ContainsFunc(emoji, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == '/'
+	})
 }
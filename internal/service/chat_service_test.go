@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"talk-backend/internal/models"
+)
+
+func TestDeleteMessageRemovesAttachmentObjects(t *testing.T) {
+	tests := []struct {
+		scope string
+		kept  bool
+	}{
+		{scope: DeleteForMe, kept: true},
+		{scope: DeleteForEveryone, kept: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			store := newMemStore()
+			attachments := newMemAttachments()
+			messages := newMemMessages(attachments, models.Message{ID: 5, ConversationID: 1, SenderID: aliceID, Content: "see file"})
+			members := &memMembers{members: map[uint][]string{1: {aliceID}}}
+			svc := NewChatService(nil, newMemUsers(), members, messages, attachments, store, &recordingEvents{}, ChatConfig{})
+
+			msgID := uint(5)
+			att := &models.Attachment{ConversationID: 1, MessageID: &msgID, UploaderID: aliceID, StorageKey: "conversations/1/file.txt"}
+			store.Put(context.Background(), att.StorageKey, strings.NewReader("file"), 4, "text/plain")
+			attachments.Create(att)
+
+			if err := svc.DeleteMessage(aliceID, 1, 5, tt.scope); err != nil {
+				t.Fatal(err)
+			}
+			if got := store.has(att.StorageKey); got != tt.kept {
+				t.Errorf("object kept = %v, want %v", got, tt.kept)
+			}
+		})
+	}
+}
+
+// A message that is already gone must still refuse members who could not
+// have deleted it, rather than reporting success.
+func TestDeleteForEveryoneChecksPermissionFirst(t *testing.T) {
+	const carolID = "7f1f7b1e-9c3e-4d6a-9a57-2f4f3c1f0c33"
+	tests := []struct {
+		name    string
+		me      string
+		wantErr error
+	}{
+		{name: "sender", me: bobID},
+		{name: "admin", me: carolID},
+		{name: "plain member", me: aliceID, wantErr: ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deletedAt := time.Now()
+			attachments := newMemAttachments()
+			messages := newMemMessages(attachments, models.Message{ID: 5, ConversationID: 1, SenderID: bobID, DeletedAt: &deletedAt})
+			members := &memMembers{
+				members: map[uint][]string{1: {aliceID, bobID, carolID}},
+				admins:  map[string]bool{carolID: true},
+			}
+			svc := NewChatService(nil, newMemUsers(), members, messages, attachments, newMemStore(), &recordingEvents{}, ChatConfig{})
+
+			if err := svc.DeleteMessage(tt.me, 1, 5, DeleteForEveryone); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateGroupConversation(t *testing.T) {
+	const unknownID = "00000000-0000-4000-8000-000000000000"
+	tests := []struct {
+		name    string
+		ids     []string
+		wantErr error
+		members []string
+	}{
+		{name: "known users", ids: []string{bobID}, members: []string{aliceID, bobID}},
+		{name: "owner and repeats are added once", ids: []string{aliceID, bobID, bobID}, members: []string{aliceID, bobID}},
+		{name: "no other member", members: []string{aliceID}},
+		{name: "unknown user creates nothing", ids: []string{bobID, unknownID}, wantErr: ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newMemUsers(models.User{ID: aliceID}, models.User{ID: bobID})
+			convs := &memMembers{}
+			svc := NewChatService(newTxDB(t), users, convs, nil, nil, nil, &recordingEvents{}, ChatConfig{})
+
+			conv, err := svc.CreateGroupConversation(aliceID, "team", tt.ids)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(convs.members) != 0 {
+					t.Errorf("conversations created: %v", convs.members)
+				}
+				return
+			}
+			if !slices.Equal(convs.members[conv.ID], tt.members) {
+				t.Errorf("members %v, want %v", convs.members[conv.ID], tt.members)
+			}
+		})
+	}
+}
+
+func TestAddMembers(t *testing.T) {
+	const carolID = "7f1f7b1e-9c3e-4d6a-9a57-2f4f3c1f0c33"
+	const unknownID = "00000000-0000-4000-8000-000000000000"
+	tests := []struct {
+		name    string
+		ids     []string
+		want    []string
+		wantErr error
+		members []string
+	}{
+		{name: "new users", ids: []string{bobID, carolID}, want: []string{bobID, carolID}, members: []string{aliceID, bobID, carolID}},
+		{name: "existing member is skipped", ids: []string{aliceID, bobID}, want: []string{bobID}, members: []string{aliceID, bobID}},
+		{name: "repeated ID is added once", ids: []string{bobID, bobID}, want: []string{bobID}, members: []string{aliceID, bobID}},
+		{name: "unknown user adds nobody", ids: []string{bobID, unknownID}, wantErr: ErrUnknownUser, members: []string{aliceID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newMemUsers(models.User{ID: aliceID}, models.User{ID: bobID}, models.User{ID: carolID})
+			convs := &memMembers{members: map[uint][]string{1: {aliceID}}, admins: map[string]bool{aliceID: true}}
+			svc := NewChatService(nil, users, convs, nil, nil, nil, &recordingEvents{}, ChatConfig{})
+
+			got, err := svc.AddMembers(aliceID, 1, tt.ids)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("added %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(convs.members[1], tt.members) {
+				t.Errorf("members %v, want %v", convs.members[1], tt.members)
+			}
+		})
+	}
+}
+
+func TestSendMessageIsIdempotent(t *testing.T) {
+	type send struct {
+		sender   string
+		convID   uint
+		clientID string
+	}
+	tests := []struct {
+		name        string
+		first, then send
+		wantErr     error
+		sameMessage bool
+		published   int
+	}{
+		{
+			name:        "retry returns the stored message",
+			first:       send{aliceID, 1, "k1"},
+			then:        send{aliceID, 1, "k1"},
+			sameMessage: true,
+			published:   1,
+		},
+		{
+			name:      "key reused in another conversation",
+			first:     send{aliceID, 1, "k1"},
+			then:      send{aliceID, 2, "k1"},
+			wantErr:   ErrClientIDReused,
+			published: 1,
+		},
+		{
+			name:      "keys are per sender",
+			first:     send{aliceID, 1, "k1"},
+			then:      send{bobID, 1, "k1"},
+			published: 2,
+		},
+		{
+			name:      "no key posts twice",
+			first:     send{aliceID, 1, ""},
+			then:      send{aliceID, 1, ""},
+			published: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members := &memMembers{members: map[uint][]string{1: {aliceID, bobID}, 2: {aliceID}}}
+			messages := newMemMessages(newMemAttachments())
+			events := &recordingEvents{}
+			svc := NewChatService(newTxDB(t), newMemUsers(), members, messages, nil, nil, events, ChatConfig{})
+
+			first, err := svc.SendMessage(tt.first.sender, tt.first.convID, NewMessage{Content: "hi", ClientMessageID: tt.first.clientID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			then, err := svc.SendMessage(tt.then.sender, tt.then.convID, NewMessage{Content: "hi", ClientMessageID: tt.then.clientID})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("second send: err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (then.ID == first.ID) != tt.sameMessage {
+				t.Errorf("second send returned message %d after %d, same = %v", then.ID, first.ID, tt.sameMessage)
+			}
+			if len(events.events) != tt.published {
+				t.Errorf("published %d events, want %d", len(events.events), tt.published)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"time"
+
+	"talk-backend/internal/models"
+)
+
+const (
+	EventMessage         = "message"
+	EventMessageEdited   = "message_edited"
+	EventMessageDeleted  = "message_deleted"
+	EventReactionAdded   = "reaction_added"
+	EventReactionRemoved = "reaction_removed"
+	EventDelivered       = "delivered"
+	EventRead            = "read"
+	EventMemberJoined    = "member_joined"
+	EventMemberLeft      = "member_left"
+	EventMemberRemoved   = "member_removed"
+)
+
+// EventPublisher pushes realtime events to the clients connected to a
+// conversation and keeps their subscriptions in line with membership. It is
+// implemented by ws.Hub.
+type EventPublisher interface {
+	PublishToConversation(conversationID uint, event any)
+	PublishToMember(conversationID uint, userID string, event any)
+	SubscribeToConversation(conversationID uint, userID string)
+	UnsubscribeFromConversation(conversationID uint, userID string)
+}
+
+type MemberEvent struct {
+	Type           string `json:"type"`
+	ConversationID uint   `json:"conversationId"`
+	UserID         string `json:"userId"`
+	ActorID        string `json:"actorId,omitempty"`
+}
+
+type ReactionEvent struct {
+	Type           string `json:"type"`
+	ConversationID uint   `json:"conversationId"`
+	MessageID      uint   `json:"messageId"`
+	UserID         string `json:"userId"`
+	Emoji          string `json:"emoji"`
+}
+
+// ReceiptEvent announces that a member's delivered or read cursor moved up to
+// MessageID.
+type ReceiptEvent struct {
+	Type           string    `json:"type"`
+	ConversationID uint      `json:"conversationId"`
+	UserID         string    `json:"userId"`
+	MessageID      uint      `json:"messageId"`
+	At             time.Time `json:"at"`
+}
+
+type MessageDeletedEvent struct {
+	Type           string `json:"type"`
+	ConversationID uint   `json:"conversationId"`
+	MessageID      uint   `json:"messageId"`
+	Scope          string `json:"scope"`
+	DeletedBy      string `json:"deletedBy"`
+}
+
+type MessageEvent struct {
+	Type           string         `json:"type"`
+	ConversationID uint           `json:"conversationId"`
+	Message        MessagePayload `json:"message"`
+}
+
+type MessagePayload struct {
+	ID             uint       `json:"id"`
+	ConversationID uint       `json:"conversationId"`
+	SenderID       string     `json:"senderId"`
+	Content        string     `json:"content"`
+	SentAt         time.Time  `json:"sentAt"`
+	ReplyToID      *uint      `json:"replyTo,omitempty"`
+	ThreadRootID   *uint      `json:"threadRoot,omitempty"`
+	EditedAt       *time.Time `json:"editedAt,omitempty"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty"`
+
+	// ClientMessageID lets the sender's devices match the event to the
+	// message they optimistically rendered.
+	ClientMessageID *string `json:"clientMessageId,omitempty"`
+
+	Attachments []AttachmentPayload `json:"attachments,omitempty"`
+}
+
+type AttachmentPayload struct {
+	ID       uint   `json:"id"`
+	FileName string `json:"fileName"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+func NewMessagePayload(m *models.Message) MessagePayload {
+	p := MessagePayload{
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		Content:        m.Content,
+		SentAt:         m.SentAt,
+		ReplyToID:      m.ReplyToID,
+		ThreadRootID:   m.ThreadRootID,
+		EditedAt:       m.EditedAt,
+		DeletedAt:      m.DeletedAt,
+
+		ClientMessageID: m.ClientMessageID,
+	}
+	for _, a := range m.Attachments {
+		p.Attachments = append(p.Attachments, AttachmentPayload{
+			ID:       a.ID,
+			FileName: a.FileName,
+			MimeType: a.MimeType,
+			Size:     a.Size,
+			Checksum: a.Checksum,
+		})
+	}
+	return p
+}
@@ -0,0 +1,729 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"talk-backend/internal/models"
+	"talk-backend/internal/repository"
+	"talk-backend/internal/storage"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// In-memory repositories for service tests. They follow the contracts of the
+// gorm implementations closely enough for the services' decisions to be
+// exercised without a database.
+
+// txDriver is a database/sql driver whose connections can only begin and
+// end transactions: enough for services that wrap calls to the in-memory
+// repositories in gorm.DB.Transaction.
+type txDriver struct{}
+
+type txConn struct{}
+
+func (txDriver) Open(string) (driver.Conn, error) { return txConn{}, nil }
+
+var errNoQueries = errors.New("txDriver runs no queries")
+
+func (txConn) Prepare(string) (driver.Stmt, error) { return nil, errNoQueries }
+func (txConn) Close() error                        { return nil }
+func (txConn) Begin() (driver.Tx, error)           { return txConn{}, nil }
+func (txConn) Commit() error                       { return nil }
+func (txConn) Rollback() error                     { return nil }
+
+func init() {
+	sql.Register("txonly", txDriver{})
+}
+
+func newTxDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn, err := sql.Open("txonly", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type memUsers struct {
+	mu    sync.Mutex
+	users map[string]*models.User
+}
+
+func newMemUsers(users ...models.User) *memUsers {
+	m := &memUsers{users: make(map[string]*models.User)}
+	for i := range users {
+		u := users[i]
+		m.users[u.ID] = &u
+	}
+	return m
+}
+
+func (m *memUsers) Create(u *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.users {
+		if existing.Email == u.Email {
+			return repository.ErrEmailAlreadyExists
+		}
+	}
+	cp := *u
+	m.users[u.ID] = &cp
+	return nil
+}
+
+func (m *memUsers) FindByID(id string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (m *memUsers) FindByEmail(email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Email == email {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *memUsers) Update(u *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *u
+	cp.TokenVersion = m.users[u.ID].TokenVersion
+	m.users[u.ID] = &cp
+	return nil
+}
+
+func (m *memUsers) FindByIDs(ids []string) ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.User
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			out = append(out, *u)
+		}
+	}
+	return out, nil
+}
+
+func (m *memUsers) UpdateLastSeen(id string, when time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; ok {
+		u.LastSeenAt = &when
+	}
+	return nil
+}
+
+func (m *memUsers) BumpTokenVersion(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; ok {
+		u.TokenVersion++
+	}
+	return nil
+}
+
+type memTokens struct {
+	mu     sync.Mutex
+	users  *memUsers
+	tokens []*models.RefreshToken
+	nextID uint
+
+	beforeRotate func()
+}
+
+func newMemTokens(users *memUsers) *memTokens {
+	return &memTokens{users: users}
+}
+
+func (m *memTokens) Create(rt *models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.create(rt)
+	return nil
+}
+
+func (m *memTokens) create(rt *models.RefreshToken) {
+	m.nextID++
+	rt.ID = m.nextID
+	rt.CreatedAt = time.Now()
+	cp := *rt
+	m.tokens = append(m.tokens, &cp)
+}
+
+// withUser returns a copy of rt with User loaded, like Preload("User").
+func (m *memTokens) withUser(rt *models.RefreshToken) *models.RefreshToken {
+	cp := *rt
+	if u, err := m.users.FindByID(rt.UserID); err == nil {
+		cp.User = *u
+	}
+	return &cp
+}
+
+func (m *memTokens) FindByHash(hash string) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rt := range m.tokens {
+		if rt.TokenHash == hash {
+			return m.withUser(rt), nil
+		}
+	}
+	return nil, repository.ErrRefreshTokenNotFound
+}
+
+func (m *memTokens) FindValidByHash(hash string) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rt := range m.tokens {
+		if rt.TokenHash == hash && rt.RevokedAt == nil && rt.ExpiresAt.After(time.Now()) {
+			return m.withUser(rt), nil
+		}
+	}
+	return nil, repository.ErrRefreshTokenNotFound
+}
+
+func (m *memTokens) Rotate(old, next *models.RefreshToken, when time.Time) error {
+	// beforeRotate lets a test run a competing request between the lookup
+	// and the rotation.
+	if f := m.beforeRotate; f != nil {
+		m.beforeRotate = nil
+		f()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.byID(old.ID)
+	if stored == nil || stored.RevokedAt != nil {
+		return repository.ErrRefreshTokenRevoked
+	}
+	m.create(next)
+	stored.RevokedAt = &when
+	stored.ReplacedByID = &next.ID
+	stored.FamilyID = next.FamilyID
+	old.RevokedAt, old.ReplacedByID, old.FamilyID = stored.RevokedAt, stored.ReplacedByID, stored.FamilyID
+	return nil
+}
+
+func (m *memTokens) Revoke(rt *models.RefreshToken, when time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rt.RevokedAt = &when
+	if stored := m.byID(rt.ID); stored != nil {
+		stored.RevokedAt = &when
+	}
+	return nil
+}
+
+func (m *memTokens) RevokeFamily(userID, familyID string, when time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, rt := range m.tokens {
+		if rt.UserID == userID && rt.FamilyID == familyID && rt.RevokedAt == nil {
+			rt.RevokedAt = &when
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memTokens) RevokeOtherFamilies(userID, keepFamilyID string, when time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var families []string
+	for _, rt := range m.tokens {
+		if rt.UserID == userID && rt.FamilyID != keepFamilyID && rt.RevokedAt == nil {
+			rt.RevokedAt = &when
+			families = append(families, rt.FamilyID)
+		}
+	}
+	return families, nil
+}
+
+func (m *memTokens) RevokeAll(userID string, when time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rt := range m.tokens {
+		if rt.UserID == userID && rt.RevokedAt == nil {
+			rt.RevokedAt = &when
+		}
+	}
+	return nil
+}
+
+func (m *memTokens) IsFamilyActive(userID, familyID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rt := range m.tokens {
+		if rt.UserID == userID && rt.FamilyID == familyID && rt.RevokedAt == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memTokens) ListActive(userID string) ([]models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.RefreshToken
+	for _, rt := range m.tokens {
+		if rt.UserID == userID && rt.RevokedAt == nil && rt.ExpiresAt.After(time.Now()) {
+			out = append(out, *rt)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out, nil
+}
+
+func (m *memTokens) Update(rt *models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored := m.byID(rt.ID); stored != nil {
+		*stored = *rt
+	}
+	return nil
+}
+
+func (m *memTokens) byID(id uint) *models.RefreshToken {
+	for _, rt := range m.tokens {
+		if rt.ID == id {
+			return rt
+		}
+	}
+	return nil
+}
+
+type memAudit struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *memAudit) Create(l *models.AuditLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, l.Event)
+	return nil
+}
+
+func (m *memAudit) has(event string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Contains(m.events, event)
+}
+
+// recordingCloser is a SessionCloser that remembers what it was asked to
+// close.
+type recordingCloser struct {
+	mu       sync.Mutex
+	sessions []string
+	users    []string
+}
+
+func (c *recordingCloser) CloseSession(userID, sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = append(c.sessions, sessionID)
+}
+
+func (c *recordingCloser) CloseUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users = append(c.users, userID)
+}
+
+type memTickets struct {
+	mu      sync.Mutex
+	tickets map[string]models.WSTicket
+}
+
+func newMemTickets() *memTickets {
+	return &memTickets{tickets: make(map[string]models.WSTicket)}
+}
+
+func (m *memTickets) Create(t *models.WSTicket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tickets[t.TokenHash] = *t
+	return nil
+}
+
+func (m *memTickets) Consume(hash string, now time.Time) (*models.WSTicket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tickets[hash]
+	if !ok || !t.ExpiresAt.After(now) {
+		return nil, repository.ErrTicketNotFound
+	}
+	delete(m.tickets, hash)
+	return &t, nil
+}
+
+func (m *memTickets) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, t := range m.tickets {
+		if !t.ExpiresAt.After(now) {
+			delete(m.tickets, hash)
+		}
+	}
+	return nil
+}
+
+// memMembers answers membership questions about group conversations. Users
+// in admins manage every group they belong to. ConversationRepository
+// methods the tests do not need are not implemented and panic if called.
+type memMembers struct {
+	repository.ConversationRepository
+	mu      sync.Mutex
+	members map[uint][]string
+	admins  map[string]bool
+}
+
+func (m *memMembers) FindByID(id uint) (*models.Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.members[id]; !ok {
+		return nil, repository.ErrConversationNotFound
+	}
+	return &models.Conversation{ID: id, IsGroup: true}, nil
+}
+
+func (m *memMembers) IsMember(conversationID uint, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Contains(m.members[conversationID], userID), nil
+}
+
+func (m *memMembers) GetMember(conversationID uint, userID string) (*models.ConversationMember, error) {
+	if ok, _ := m.IsMember(conversationID, userID); !ok {
+		return nil, repository.ErrMemberNotFound
+	}
+	role := models.RoleMember
+	if m.admins[userID] {
+		role = models.RoleAdmin
+	}
+	return &models.ConversationMember{ConversationID: conversationID, UserID: userID, Role: role}, nil
+}
+
+func (m *memMembers) CreateConversation(tx *gorm.DB, conv *models.Conversation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.members == nil {
+		m.members = make(map[uint][]string)
+	}
+	conv.ID = uint(len(m.members) + 1)
+	m.members[conv.ID] = nil
+	return nil
+}
+
+func (m *memMembers) AddMembers(tx *gorm.DB, members []models.ConversationMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mem := range members {
+		m.members[mem.ConversationID] = append(m.members[mem.ConversationID], mem.UserID)
+	}
+	return nil
+}
+
+func (m *memMembers) Touch(tx *gorm.DB, conversationID uint, when time.Time) error {
+	return nil
+}
+
+func (m *memMembers) AddNewMembers(tx *gorm.DB, members []models.ConversationMember) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	added := []string{}
+	for _, mem := range members {
+		if !slices.Contains(m.members[mem.ConversationID], mem.UserID) {
+			m.members[mem.ConversationID] = append(m.members[mem.ConversationID], mem.UserID)
+			added = append(added, mem.UserID)
+		}
+	}
+	return added, nil
+}
+
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (s *memStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = b
+	return nil
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[key]
+	if !ok {
+		return nil, storage.ErrObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStore) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+type memAttachments struct {
+	mu     sync.Mutex
+	rows   map[uint]models.Attachment
+	nextID uint
+}
+
+func newMemAttachments() *memAttachments {
+	return &memAttachments{rows: make(map[uint]models.Attachment)}
+}
+
+func (m *memAttachments) Create(att *models.Attachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	att.ID = m.nextID
+	if att.CreatedAt.IsZero() {
+		att.CreatedAt = time.Now()
+	}
+	m.rows[att.ID] = *att
+	return nil
+}
+
+func (m *memAttachments) FindByID(id uint) (*models.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	att, ok := m.rows[id]
+	if !ok {
+		return nil, repository.ErrAttachmentNotFound
+	}
+	return &att, nil
+}
+
+func (m *memAttachments) AttachToMessage(tx *gorm.DB, ids []uint, uploaderID string, msg *models.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		att, ok := m.rows[id]
+		if !ok || att.UploaderID != uploaderID || att.ConversationID != msg.ConversationID || att.MessageID != nil {
+			return repository.ErrAttachmentNotFound
+		}
+	}
+	for _, id := range ids {
+		att := m.rows[id]
+		att.MessageID = &msg.ID
+		m.rows[id] = att
+		msg.Attachments = append(msg.Attachments, att)
+	}
+	return nil
+}
+
+func (m *memAttachments) DeletePendingBefore(cutoff time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for id, att := range m.rows {
+		if att.MessageID == nil && att.CreatedAt.Before(cutoff) {
+			keys = append(keys, att.StorageKey)
+			delete(m.rows, id)
+		}
+	}
+	return keys, nil
+}
+
+// deleteMessage drops the attachments of a message, as SoftDelete does.
+func (m *memAttachments) deleteMessage(messageID uint) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for id, att := range m.rows {
+		if att.MessageID != nil && *att.MessageID == messageID {
+			keys = append(keys, att.StorageKey)
+			delete(m.rows, id)
+		}
+	}
+	return keys
+}
+
+// memMessages stores messages by ID; methods the tests do not need are not
+// implemented and panic if called.
+type memMessages struct {
+	repository.MessageRepository
+	mu          sync.Mutex
+	messages    map[uint]*models.Message
+	nextID      uint
+	attachments *memAttachments
+}
+
+func newMemMessages(attachments *memAttachments, messages ...models.Message) *memMessages {
+	m := &memMessages{messages: make(map[uint]*models.Message), attachments: attachments}
+	for i := range messages {
+		msg := messages[i]
+		m.messages[msg.ID] = &msg
+		m.nextID = max(m.nextID, msg.ID)
+	}
+	return m
+}
+
+// Create enforces the unique (sender_id, client_message_id) index the way
+// the gorm implementation reports it.
+func (m *memMessages) Create(tx *gorm.DB, msg *models.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg.ClientMessageID != nil {
+		for _, existing := range m.messages {
+			if existing.SenderID == msg.SenderID && existing.ClientMessageID != nil && *existing.ClientMessageID == *msg.ClientMessageID {
+				*msg = *existing
+				return repository.ErrDuplicateMessage
+			}
+		}
+	}
+	m.nextID++
+	msg.ID = m.nextID
+	cp := *msg
+	m.messages[msg.ID] = &cp
+	return nil
+}
+
+func (m *memMessages) FindByID(id uint) (*models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.messages[id]
+	if !ok {
+		return nil, repository.ErrMessageNotFound
+	}
+	cp := *msg
+	return &cp, nil
+}
+
+func (m *memMessages) HideForUser(messageID uint, userID string) error {
+	return nil
+}
+
+func (m *memMessages) SoftDelete(msg *models.Message, deletedBy string, when time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.messages[msg.ID]
+	stored.Content, stored.DeletedAt, stored.DeletedBy = "", &when, &deletedBy
+	*msg = *stored
+	return m.attachments.deleteMessage(msg.ID), nil
+}
+
+// recordingEvents is an EventPublisher that keeps the published events.
+type recordingEvents struct {
+	mu     sync.Mutex
+	events []any
+}
+
+func (e *recordingEvents) PublishToConversation(conversationID uint, event any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *recordingEvents) PublishToMember(conversationID uint, userID string, event any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *recordingEvents) SubscribeToConversation(conversationID uint, userID string)     {}
+func (e *recordingEvents) UnsubscribeFromConversation(conversationID uint, userID string) {}
+
+type memSigningKeys struct {
+	mu     sync.Mutex
+	keys   []models.SigningKey
+	nextID uint
+}
+
+func (m *memSigningKeys) ListUsable(now time.Time) ([]models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usable(now), nil
+}
+
+func (m *memSigningKeys) usable(now time.Time) []models.SigningKey {
+	var out []models.SigningKey
+	for _, k := range m.keys {
+		if k.RetiresAt == nil || k.RetiresAt.After(now) {
+			out = append(out, k)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ActivatesAt.Before(out[j].ActivatesAt) })
+	return out
+}
+
+func (m *memSigningKeys) Rotate(now time.Time, decide repository.RotationFunc) (*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = slices.DeleteFunc(m.keys, func(k models.SigningKey) bool {
+		return k.RetiresAt != nil && !k.RetiresAt.After(now)
+	})
+
+	next, retireAt := decide(m.usable(now))
+	if next == nil {
+		return nil, nil
+	}
+	for i := range m.keys {
+		if m.keys[i].RetiresAt == nil {
+			m.keys[i].RetiresAt = &retireAt
+		}
+	}
+	m.nextID++
+	next.ID = m.nextID
+	m.keys = append(m.keys, *next)
+	return next, nil
+}
+
+// update changes the stored key kid, e.g. to move it back in time.
+func (m *memSigningKeys) update(kid string, change func(k *models.SigningKey)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.keys {
+		if m.keys[i].KID == kid {
+			change(&m.keys[i])
+		}
+	}
+}
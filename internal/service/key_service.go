@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"talk-backend/internal/models"
+	"talk-backend/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrNoSigningKey = errors.New("no active signing key")
+)
+
+type KeyConfig struct {
+	// Algorithm is "EdDSA" or "RS256". Changing it rotates the key.
+	Algorithm string
+	// Secret seals the private keys stored in the database.
+	Secret string
+	// Rotation is how long a key signs before its successor takes over.
+	Rotation time.Duration
+	// Prepublish is how long a new key is in the JWKS before it signs, so
+	// verifiers that cache the set learn it in time.
+	Prepublish time.Duration
+	// Grace is how long a replaced key is still accepted. It must cover the
+	// lifetime of an access token.
+	Grace time.Duration
+	// Refresh is how often keys are reloaded and rotation is checked.
+	Refresh time.Duration
+}
+
+// PublicKey is a verification key as published in the JWKS.
+type PublicKey struct {
+	KID       string
+	Algorithm string
+	Key       crypto.PublicKey
+}
+
+// KeyService signs access tokens and finds the key to verify them with. The
+// keys are shared by all replicas through the database; each replica reloads
+// them every Refresh and the first to notice the current key is due creates
+// its successor.
+type KeyService struct {
+	keys repository.SigningKeyRepository
+	cfg  KeyConfig
+	aead cipher.AEAD
+
+	mu      sync.RWMutex
+	signing *signingKey
+	byKID   map[string]*signingKey
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	public    crypto.PublicKey
+	activates time.Time
+	retires   *time.Time
+}
+
+// NewKeyService loads the keys, creating the first one if there is none, so
+// tokens can be signed as soon as it returns.
+func NewKeyService(keys repository.SigningKeyRepository, cfg KeyConfig) (*KeyService, error) {
+	kek, err := hkdf.Key(sha256.New, []byte(cfg.Secret), nil, "talk-backend signing keys", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &KeyService{
+		keys:  keys,
+		cfg:   cfg,
+		aead:  aead,
+		byKID: make(map[string]*signingKey),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := s.refresh(); err != nil {
+		return nil, fmt.Errorf("load signing keys: %w", err)
+	}
+	return s, nil
+}
+
+// Run reloads the keys and rotates them when due, until Stop is called.
+func (s *KeyService) Run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			// On failure the keys loaded last time stay in use.
+			if err := s.refresh(); err != nil {
+				log.Printf("[KEYS] cannot refresh signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// Stop ends Run, waiting for a refresh in progress to finish.
+func (s *KeyService) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sign signs claims with the current key and names it in the "kid" header.
+func (s *KeyService) Sign(claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	k := s.signing
+	s.mu.RUnlock()
+	if k == nil {
+		return "", ErrNoSigningKey
+	}
+
+	t := jwt.NewWithClaims(k.method, claims)
+	t.Header["kid"] = k.kid
+	return t.SignedString(k.private)
+}
+
+// Keyfunc is a jwt.Keyfunc that picks the key named by the token's "kid"
+// header. The token must use the algorithm of that key, so a public key can
+// never be taken for an HMAC secret.
+func (s *KeyService) Keyfunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	s.mu.RLock()
+	k, ok := s.byKID[kid]
+	s.mu.RUnlock()
+	if !ok || (k.retires != nil && !time.Now().Before(*k.retires)) {
+		return nil, ErrUnknownKey
+	}
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return k.public, nil
+}
+
+// PublicKeys returns the keys tokens may currently be verified with,
+// including the next key before it starts signing.
+func (s *KeyService) PublicKeys() []PublicKey {
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]PublicKey, 0, len(s.byKID))
+	for _, k := range s.byKID {
+		if k.retires != nil && !now.Before(*k.retires) {
+			continue
+		}
+		out = append(out, PublicKey{KID: k.kid, Algorithm: k.method.Alg(), Key: k.public})
+	}
+	return out
+}
+
+// CacheTTL is how long verifiers may cache PublicKeys. It is short enough
+// for a new key to reach them during its prepublication, even when it was
+// served by a replica that had not reloaded yet.
+func (s *KeyService) CacheTTL() time.Duration {
+	return (s.cfg.Prepublish - s.cfg.Refresh) / 2
+}
+
+// refresh rotates the keys if due, then reloads them.
+func (s *KeyService) refresh() error {
+	now := time.Now()
+	if _, err := s.keys.Rotate(now, s.rotation(now)); err != nil {
+		return err
+	}
+	usable, err := s.keys.ListUsable(now)
+	if err != nil {
+		return err
+	}
+
+	var signing *signingKey
+	byKID := make(map[string]*signingKey, len(usable))
+	for i := range usable {
+		k, err := s.open(&usable[i])
+		if err != nil {
+			log.Printf("[KEYS] skipping key %s: %v", usable[i].KID, err)
+			continue
+		}
+		byKID[k.kid] = k
+		// Keys are ordered by activation, so the last active one wins.
+		if !k.activates.After(now) {
+			signing = k
+		}
+	}
+	if signing == nil {
+		return ErrNoSigningKey
+	}
+
+	s.mu.Lock()
+	s.signing = signing
+	s.byKID = byKID
+	s.mu.Unlock()
+	return nil
+}
+
+// rotation decides whether a successor to the current key is due. Keys that
+// cannot be opened, e.g. after JWT_SECRET changed, are ignored, so a fresh
+// key is created for immediate use when none is left.
+func (s *KeyService) rotation(now time.Time) repository.RotationFunc {
+	return func(usable []models.SigningKey) (*models.SigningKey, time.Time) {
+		var current, pending *models.SigningKey
+		for i := range usable {
+			k := &usable[i]
+			if _, err := s.open(k); err != nil {
+				continue
+			}
+			if k.ActivatesAt.After(now) {
+				pending = k
+			} else {
+				current = k
+			}
+		}
+
+		var activates time.Time
+		switch {
+		case current == nil:
+			activates = now
+		case pending != nil:
+			return nil, time.Time{}
+		case current.Algorithm != s.cfg.Algorithm,
+			!now.Before(current.ActivatesAt.Add(s.cfg.Rotation - s.cfg.Prepublish)):
+			activates = now.Add(s.cfg.Prepublish)
+		default:
+			return nil, time.Time{}
+		}
+
+		next, err := s.generate(activates)
+		if err != nil {
+			log.Printf("[KEYS] cannot generate signing key: %v", err)
+			return nil, time.Time{}
+		}
+		return next, activates.Add(s.cfg.Grace)
+	}
+}
+
+func (s *KeyService) generate(activates time.Time) (*models.SigningKey, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch s.cfg.Algorithm {
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		err = fmt.Errorf("unsupported algorithm %q", s.cfg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		KID:         kid,
+		Algorithm:   s.cfg.Algorithm,
+		PrivateKey:  s.aead.Seal(nonce, nonce, der, []byte(kid)),
+		ActivatesAt: activates,
+	}, nil
+}
+
+func (s *KeyService) open(m *models.SigningKey) (*signingKey, error) {
+	n := s.aead.NonceSize()
+	if len(m.PrivateKey) < n {
+		return nil, errors.New("sealed key too short")
+	}
+	der, err := s.aead.Open(nil, m.PrivateKey[:n], m.PrivateKey[n:], []byte(m.KID))
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &signingKey{kid: m.KID, activates: m.ActivatesAt, retires: m.RetiresAt}
+	switch p := parsed.(type) {
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodEdDSA, p, p.Public()
+	case *rsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodRS256, p, p.Public()
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	if k.method.Alg() != m.Algorithm {
+		return nil, fmt.Errorf("key is not %s", m.Algorithm)
+	}
+	return k, nil
+}
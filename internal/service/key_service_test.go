@@ -0,0 +1,184 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"talk-backend/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestKeys(t *testing.T, repo *memSigningKeys, alg, secret string) *KeyService {
+	t.Helper()
+	s, err := NewKeyService(repo, testKeyConfig(alg, secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// signedKID signs a token with s and returns the kid it names.
+func signedKID(t *testing.T, s *KeyService) (string, string) {
+	t.Helper()
+	raw, err := s.Sign(jwt.RegisteredClaims{Subject: aliceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.Parse(raw, s.Keyfunc)
+	if err != nil {
+		t.Fatalf("own token rejected: %v", err)
+	}
+	return raw, tok.Header["kid"].(string)
+}
+
+func publishedKIDs(s *KeyService) map[string]string {
+	out := map[string]string{}
+	for _, k := range s.PublicKeys() {
+		out[k.KID] = k.Algorithm
+	}
+	return out
+}
+
+func TestKeyServiceRotation(t *testing.T) {
+	cfg := testKeyConfig("EdDSA", "secret")
+	tests := []struct {
+		name string
+		// age moves the first key's activation back in time before the
+		// service refreshes.
+		age time.Duration
+		// alg and secret configure the service that refreshes.
+		alg, secret string
+		published   int
+		newSigner   bool
+		oldAccepted bool
+	}{
+		{name: "current key not due", age: time.Hour, alg: "EdDSA", secret: "secret", published: 1, oldAccepted: true},
+		{name: "successor prepublished before it signs", age: cfg.Rotation - cfg.Prepublish, alg: "EdDSA", secret: "secret", published: 2, oldAccepted: true},
+		{name: "algorithm change prepublishes a successor", age: time.Hour, alg: "RS256", secret: "secret", published: 2, oldAccepted: true},
+		{name: "secret change replaces the key at once", age: time.Hour, alg: "EdDSA", secret: "other", published: 1, newSigner: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memSigningKeys{}
+			first := newTestKeys(t, repo, "EdDSA", "secret")
+			oldToken, oldKID := signedKID(t, first)
+			repo.update(oldKID, func(k *models.SigningKey) { k.ActivatesAt = k.ActivatesAt.Add(-tt.age) })
+
+			s := newTestKeys(t, repo, tt.alg, tt.secret)
+			if got := publishedKIDs(s); len(got) != tt.published {
+				t.Errorf("published %v, want %d keys", got, tt.published)
+			}
+			if _, kid := signedKID(t, s); (kid != oldKID) != tt.newSigner {
+				t.Errorf("signing with %s after %s, new signer = %v", kid, oldKID, tt.newSigner)
+			}
+			if _, err := jwt.Parse(oldToken, s.Keyfunc); (err == nil) != tt.oldAccepted {
+				t.Errorf("old token: %v, accepted = %v", err, tt.oldAccepted)
+			}
+		})
+	}
+}
+
+func TestKeyServiceSuccessorTakesOver(t *testing.T) {
+	cfg := testKeyConfig("EdDSA", "secret")
+	repo := &memSigningKeys{}
+	s := newTestKeys(t, repo, "EdDSA", "secret")
+	oldToken, oldKID := signedKID(t, s)
+
+	// Due: the successor is published, then activated.
+	repo.update(oldKID, func(k *models.SigningKey) { k.ActivatesAt = k.ActivatesAt.Add(-cfg.Rotation) })
+	if err := s.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	var next string
+	for kid := range publishedKIDs(s) {
+		if kid != oldKID {
+			next = kid
+		}
+	}
+	repo.update(next, func(k *models.SigningKey) { k.ActivatesAt = time.Now().Add(-time.Second) })
+	if err := s.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, kid := signedKID(t, s); kid != next {
+		t.Fatalf("signing with %s, want successor %s", kid, next)
+	}
+	if _, err := jwt.Parse(oldToken, s.Keyfunc); err != nil {
+		t.Errorf("token of the replaced key rejected during grace: %v", err)
+	}
+
+	// Grace over: the replaced key is withdrawn.
+	repo.update(oldKID, func(k *models.SigningKey) {
+		past := time.Now().Add(-time.Second)
+		k.RetiresAt = &past
+	})
+	if err := s.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := publishedKIDs(s)[oldKID]; ok {
+		t.Error("retired key still published")
+	}
+	if _, err := jwt.Parse(oldToken, s.Keyfunc); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of the retired key: %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyfuncRejectsForgedTokens(t *testing.T) {
+	s := newTestKeys(t, &memSigningKeys{}, "EdDSA", "secret")
+	_, kid := signedKID(t, s)
+	pub := s.PublicKeys()[0].Key.(ed25519.PublicKey)
+	claims := jwt.RegisteredClaims{Subject: aliceID}
+
+	tests := []struct {
+		name string
+		sign func() string
+	}{
+		{
+			name: "public key used as HMAC secret",
+			sign: func() string {
+				tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+				tok.Header["kid"] = kid
+				raw, _ := tok.SignedString([]byte(pub))
+				return raw
+			},
+		},
+		{
+			name: "unsigned token",
+			sign: func() string {
+				tok := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+				tok.Header["kid"] = kid
+				raw, _ := tok.SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return raw
+			},
+		},
+		{
+			name: "unknown kid",
+			sign: func() string {
+				_, priv, _ := ed25519.GenerateKey(nil)
+				tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+				tok.Header["kid"] = "nope"
+				raw, _ := tok.SignedString(priv)
+				return raw
+			},
+		},
+		{
+			name: "foreign key under a known kid",
+			sign: func() string {
+				_, priv, _ := ed25519.GenerateKey(nil)
+				tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+				tok.Header["kid"] = kid
+				raw, _ := tok.SignedString(priv)
+				return raw
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := jwt.Parse(tt.sign(), s.Keyfunc); err == nil {
+				t.Fatal("forged token accepted")
+			}
+		})
+	}
+}
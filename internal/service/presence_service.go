@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"talk-backend/internal/repository"
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+
+	EventPresence = "presence"
+)
+
+type PresenceEvent struct {
+	Type       string     `json:"type"`
+	UserID     string     `json:"userId"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+type Presence struct {
+	UserID     string
+	Status     string
+	LastSeenAt *time.Time
+}
+
+// UserPublisher delivers events to every connection of the given users,
+// whatever conversation they are subscribed to. It is implemented by ws.Hub.
+type UserPublisher interface {
+	PublishToUsers(userIDs []string, event any)
+}
+
+// PresenceService keeps track of who is connected. A user is online while at
+// least one of their connections is active, away when all of them report
+// idle, and offline once the last one closes.
+//
+// Connections are tracked per replica. With several replicas each one
+// announces the user offline when its own last connection closes, and
+// Lookup only answers from this replica's connections.
+type PresenceService struct {
+	users repository.UserRepository
+	convs repository.ConversationRepository
+
+	mu sync.Mutex
+	// userID -> connection ID -> away
+	conns map[string]map[uint64]bool
+
+	publisher UserPublisher
+	changes   chan PresenceEvent
+	done      chan struct{}
+	// stopped is guarded by mu; changes is closed once it is set.
+	stopped bool
+}
+
+func NewPresenceService(users repository.UserRepository, convs repository.ConversationRepository) *PresenceService {
+	return &PresenceService{
+		users:   users,
+		convs:   convs,
+		conns:   make(map[string]map[uint64]bool),
+		changes: make(chan PresenceEvent, 1024),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run persists state changes and fans them out to the user's contacts. It is
+// kept off the caller's goroutine because the hub reports connections from
+// its own loop and must never wait on the database.
+func (s *PresenceService) Run(publisher UserPublisher) {
+	defer close(s.done)
+	s.publisher = publisher
+	for ev := range s.changes {
+		if ev.Status == PresenceOffline && ev.LastSeenAt != nil {
+			if err := s.users.UpdateLastSeen(ev.UserID, *ev.LastSeenAt); err != nil {
+				log.Printf("[PRESENCE] cannot save last seen for %s: %v", ev.UserID, err)
+			}
+		}
+
+		contacts, err := s.convs.ListContactIDs(ev.UserID)
+		if err != nil {
+			log.Printf("[PRESENCE] cannot list contacts of %s: %v", ev.UserID, err)
+			continue
+		}
+		// Include the user so their other devices see the change too.
+		s.publisher.PublishToUsers(append(contacts, ev.UserID), ev)
+	}
+}
+
+// Stop ends Run once the changes already queued are saved and published, so
+// users dropped during shutdown get their last seen time. Later changes are
+// discarded.
+func (s *PresenceService) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.changes)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *PresenceService) Connected(userID string, connID uint64) {
+	s.update(userID, func(conns map[uint64]bool) { conns[connID] = false })
+}
+
+func (s *PresenceService) Disconnected(userID string, connID uint64) {
+	s.update(userID, func(conns map[uint64]bool) { delete(conns, connID) })
+}
+
+// SetAway records whether one connection is idle, as reported by the client.
+func (s *PresenceService) SetAway(userID string, connID uint64, away bool) {
+	s.update(userID, func(conns map[uint64]bool) {
+		if _, ok := conns[connID]; ok {
+			conns[connID] = away
+		}
+	})
+}
+
+func (s *PresenceService) update(userID string, change func(map[uint64]bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := s.conns[userID]
+	if conns == nil {
+		conns = make(map[uint64]bool)
+		s.conns[userID] = conns
+	}
+	before := status(conns)
+	change(conns)
+	after := status(conns)
+	if len(conns) == 0 {
+		delete(s.conns, userID)
+	}
+
+	// Queue while still holding the lock so events leave in the same order
+	// as the changes that caused them.
+	if before == after || s.stopped {
+		return
+	}
+	ev := PresenceEvent{Type: EventPresence, UserID: userID, Status: after}
+	if after == PresenceOffline {
+		now := time.Now()
+		ev.LastSeenAt = &now
+	}
+	select {
+	case s.changes <- ev:
+	default:
+		log.Printf("[PRESENCE] queue full, dropping %s update for %s", after, userID)
+	}
+}
+
+// Lookup returns the presence of the requested users that share a
+// conversation with me. Others are left out rather than reported offline.
+func (s *PresenceService) Lookup(me string, userIDs []string) ([]Presence, error) {
+	contacts, err := s.convs.ListContactIDs(me)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(contacts)+1)
+	visible[me] = true
+	for _, id := range contacts {
+		visible[id] = true
+	}
+
+	ids := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		if visible[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return []Presence{}, nil
+	}
+
+	users, err := s.users.FindByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Presence, 0, len(users))
+	for _, u := range users {
+		out = append(out, Presence{
+			UserID:     u.ID,
+			Status:     status(s.conns[u.ID]),
+			LastSeenAt: u.LastSeenAt,
+		})
+	}
+	return out, nil
+}
+
+func status(conns map[uint64]bool) string {
+	if len(conns) == 0 {
+		return PresenceOffline
+	}
+	for _, away := range conns {
+		if !away {
+			return PresenceOnline
+		}
+	}
+	return PresenceAway
+}
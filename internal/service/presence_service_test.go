@@ -0,0 +1,41 @@
+package service
+
+import (
+	"sync"
+	"testing"
+)
+
+// Connections of one user opening and closing concurrently must queue their
+// events in the order the state changed, so the last event is the real state.
+func TestPresenceEventsFollowState(t *testing.T) {
+	s := NewPresenceService(nil, nil)
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func(connID uint64) {
+			defer wg.Done()
+			for range 50 {
+				s.Connected(aliceID, connID)
+				s.Disconnected(aliceID, connID)
+			}
+		}(uint64(g))
+	}
+	wg.Wait()
+
+	var events []PresenceEvent
+	for len(s.changes) > 0 {
+		events = append(events, <-s.changes)
+	}
+	if len(events) == 0 {
+		t.Fatal("no presence events queued")
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Status == events[i-1].Status {
+			t.Fatalf("event %d repeats %s", i, events[i].Status)
+		}
+	}
+	if last := events[len(events)-1]; last.Status != PresenceOffline || last.LastSeenAt == nil {
+		t.Errorf("last event = %+v, want offline with last seen", last)
+	}
+}
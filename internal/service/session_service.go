@@ -0,0 +1,178 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"talk-backend/internal/models"
+	"talk-backend/internal/repository"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionCloser ends the live connections opened with a session once it is
+// revoked, on every replica.
+type SessionCloser interface {
+	CloseSession(userID, sessionID string)
+	CloseUser(userID string)
+}
+
+// Session is one login of a user, i.e. one refresh token family. Its ID is
+// the family ID, which access tokens carry in their "sid" claim.
+type Session struct {
+	ID         string
+	DeviceName string
+	IP         string
+	UserAgent  string
+	LastUsedAt *time.Time
+	ExpiresAt  time.Time
+	Current    bool
+}
+
+type SessionService struct {
+	users  repository.UserRepository
+	tokens repository.RefreshTokenRepository
+	audit  repository.AuditRepository
+	guard  *TokenGuard
+	closer SessionCloser
+}
+
+func NewSessionService(
+	users repository.UserRepository,
+	tokens repository.RefreshTokenRepository,
+	audit repository.AuditRepository,
+	guard *TokenGuard,
+	closer SessionCloser,
+) *SessionService {
+	return &SessionService{users: users, tokens: tokens, audit: audit, guard: guard, closer: closer}
+}
+
+// List returns my active sessions; current is the session of the request.
+func (s *SessionService) List(me, current string) ([]Session, error) {
+	tokens, err := s.tokens.ListActive(me)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Session, 0, len(tokens))
+	for _, rt := range tokens {
+		out = append(out, Session{
+			ID:         rt.FamilyID,
+			DeviceName: rt.DeviceName,
+			IP:         rt.IP,
+			UserAgent:  rt.UserAgent,
+			LastUsedAt: rt.LastUsedAt,
+			ExpiresAt:  rt.ExpiresAt,
+			Current:    rt.FamilyID == current,
+		})
+	}
+	return out, nil
+}
+
+// Revoke ends one of my sessions: it can no longer refresh, its access tokens
+// are refused and its sockets are closed.
+func (s *SessionService) Revoke(me, sessionID, ip, ua string) error {
+	n, err := s.tokens.RevokeFamily(me, sessionID, time.Now())
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	s.ended(me, sessionID)
+	s.record(me, ip, ua, "session_revoked")
+	return nil
+}
+
+// RevokeOthers ends every session of mine except current and returns how
+// many were ended.
+func (s *SessionService) RevokeOthers(me, current, ip, ua string) (int, error) {
+	revoked, err := s.tokens.RevokeOtherFamilies(me, current, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range revoked {
+		s.ended(me, id)
+	}
+	if len(revoked) > 0 {
+		s.record(me, ip, ua, "sessions_revoked")
+	}
+	return len(revoked), nil
+}
+
+// RevokeAll signs me out everywhere: every refresh token is revoked and the
+// token version is bumped, so access tokens issued before, with or without a
+// session, are refused at once.
+func (s *SessionService) RevokeAll(me, ip, ua string) error {
+	if err := s.users.BumpTokenVersion(me); err != nil {
+		return err
+	}
+	s.guard.forgetUser(me)
+	if err := s.tokens.RevokeAll(me, time.Now()); err != nil {
+		return err
+	}
+	s.closer.CloseUser(me)
+	s.record(me, ip, ua, "logout_all")
+	return nil
+}
+
+// ended applies the revocation of a session right away on this replica.
+func (s *SessionService) ended(userID, sessionID string) {
+	s.guard.forgetSession(sessionID)
+	s.closer.CloseSession(userID, sessionID)
+}
+
+func (s *SessionService) record(userID, ip, ua, event string) {
+	_ = s.audit.Create(&models.AuditLog{UserID: &userID, Event: event, IP: ip, UA: ua})
+}
+
+// describeDevice names a session after its browser and OS, e.g. "Firefox on
+// Windows", for clients that do not send a device name.
+func describeDevice(ua string) string {
+	var browser, os string
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	}
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		os = "iOS"
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+	case strings.Contains(ua, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	default:
+		return os
+	}
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"talk-backend/internal/auth"
+	"talk-backend/internal/models"
+	"talk-backend/internal/repository"
+)
+
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+type TicketConfig struct {
+	TTL time.Duration
+}
+
+// TicketService issues the single-use tickets browsers pass on the /ws URL
+// in place of an Authorization header. Tickets live in the database so any
+// replica can redeem them.
+type TicketService struct {
+	tickets repository.WSTicketRepository
+	convs   repository.ConversationRepository
+	cfg     TicketConfig
+}
+
+func NewTicketService(
+	tickets repository.WSTicketRepository,
+	convs repository.ConversationRepository,
+	cfg TicketConfig,
+) *TicketService {
+	return &TicketService{tickets: tickets, convs: convs, cfg: cfg}
+}
+
+// Issue returns a ticket for the caller of the request, optionally bound to
+// one conversation. The ticket carries the session, token version and expiry
+// of the access token the request was made with.
+func (s *TicketService) Issue(me *auth.Principal, conversationID *uint) (string, time.Time, error) {
+	if conversationID != nil {
+		ok, err := s.convs.IsMember(*conversationID, me.UserID)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if !ok {
+			return "", time.Time{}, ErrForbidden
+		}
+	}
+
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(s.cfg.TTL)
+	err = s.tickets.Create(&models.WSTicket{
+		TokenHash:      hashToken(raw),
+		UserID:         me.UserID,
+		SessionID:      me.SessionID,
+		TokenVersion:   me.Version,
+		ConversationID: conversationID,
+		AuthExpiresAt:  me.ExpiresAt,
+		ExpiresAt:      expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Redeemed tickets are deleted on use; this sweeps the abandoned ones.
+	if err := s.tickets.DeleteExpired(time.Now()); err != nil {
+		log.Printf("[TICKET] cannot delete expired tickets: %v", err)
+	}
+	return raw, expiresAt, nil
+}
+
+// Redeem consumes a ticket. It fails if the ticket is unknown, expired or
+// was already used.
+func (s *TicketService) Redeem(raw string) (*models.WSTicket, error) {
+	t, err := s.tickets.Consume(hashToken(raw), time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrTicketNotFound) {
+			return nil, ErrInvalidTicket
+		}
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"talk-backend/internal/auth"
+	"talk-backend/internal/models"
+)
+
+// A ticket bought before a logout-all must not open a socket afterwards,
+// even for tokens without a session.
+func TestTicketCarriesTokenVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessionID string
+	}{
+		{name: "with session", sessionID: "fam"},
+		{name: "legacy token without session", sessionID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newMemUsers(models.User{ID: aliceID, Email: "alice@example.com", TokenVersion: 4})
+			tokens := newMemTokens(users)
+			tokens.Create(&models.RefreshToken{UserID: aliceID, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour)})
+			guard := NewTokenGuard(users, tokens, TokenGuardConfig{CacheTTL: time.Hour})
+			sessions := NewSessionService(users, tokens, &memAudit{}, guard, &recordingCloser{})
+			tickets := NewTicketService(newMemTickets(), &memMembers{}, TicketConfig{TTL: time.Minute})
+
+			me := &auth.Principal{UserID: aliceID, SessionID: tt.sessionID, Version: 4, ExpiresAt: time.Now().Add(time.Minute)}
+			raw, _, err := tickets.Issue(me, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sessions.RevokeAll(aliceID, "", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			ticket, err := tickets.Redeem(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticket.TokenVersion != me.Version || ticket.SessionID != me.SessionID {
+				t.Fatalf("ticket = %+v, want version %d and session %q", ticket, me.Version, me.SessionID)
+			}
+			if ok, err := guard.Check(ticket.UserID, ticket.SessionID, ticket.TokenVersion); err != nil || ok {
+				t.Fatalf("Check after logout-all = %v, %v; want false", ok, err)
+			}
+		})
+	}
+}
+
+func TestTicketIssueAndRedeem(t *testing.T) {
+	const conv uint = 7
+	me := &auth.Principal{UserID: aliceID, SessionID: "fam", ExpiresAt: time.Now().Add(time.Minute)}
+
+	tests := []struct {
+		name      string
+		conv      *uint
+		members   []string
+		ttl       time.Duration
+		redeem    func(raw string) string
+		issueErr  error
+		redeemErr error
+	}{
+		{name: "valid ticket", ttl: time.Minute},
+		{name: "bound to my conversation", conv: ptr(conv), members: []string{aliceID}, ttl: time.Minute},
+		{name: "bound to someone else's conversation", conv: ptr(conv), members: []string{bobID}, ttl: time.Minute, issueErr: ErrForbidden},
+		{name: "expired", ttl: -time.Second, redeemErr: ErrInvalidTicket},
+		{name: "unknown", ttl: time.Minute, redeem: func(string) string { return "not-a-ticket" }, redeemErr: ErrInvalidTicket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tickets := NewTicketService(newMemTickets(), &memMembers{members: map[uint][]string{conv: tt.members}}, TicketConfig{TTL: tt.ttl})
+
+			raw, _, err := tickets.Issue(me, tt.conv)
+			if !errors.Is(err, tt.issueErr) {
+				t.Fatalf("Issue error = %v, want %v", err, tt.issueErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.redeem != nil {
+				raw = tt.redeem(raw)
+			}
+
+			ticket, err := tickets.Redeem(raw)
+			if !errors.Is(err, tt.redeemErr) {
+				t.Fatalf("Redeem error = %v, want %v", err, tt.redeemErr)
+			}
+			if err != nil {
+				return
+			}
+			if ticket.UserID != me.UserID || !ticket.AuthExpiresAt.Equal(me.ExpiresAt) {
+				t.Fatalf("ticket = %+v, want it to carry the principal", ticket)
+			}
+			if (ticket.ConversationID == nil) != (tt.conv == nil) {
+				t.Fatalf("ticket conversation = %v, want %v", ticket.ConversationID, tt.conv)
+			}
+
+			// Tickets are single-use.
+			if _, err := tickets.Redeem(raw); !errors.Is(err, ErrInvalidTicket) {
+				t.Fatalf("second Redeem error = %v, want %v", err, ErrInvalidTicket)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
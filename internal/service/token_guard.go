@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"talk-backend/internal/repository"
+)
+
+type TokenGuardConfig struct {
+	// CacheTTL is how long an answer is reused. Revocations made on this
+	// replica apply at once; other replicas see them within CacheTTL.
+	CacheTTL time.Duration
+}
+
+// TokenGuard decides whether a correctly signed access token is still
+// honoured: the user's token version must not have moved past the one in the
+// token, and the session it was issued to must not have been revoked.
+type TokenGuard struct {
+	users  repository.UserRepository
+	tokens repository.RefreshTokenRepository
+	cfg    TokenGuardConfig
+
+	mu       sync.Mutex
+	versions map[string]guardEntry
+	sessions map[string]guardEntry
+}
+
+// guardEntry is a cached answer: a user's version or whether a session is
+// active.
+type guardEntry struct {
+	version int
+	active  bool
+	expires time.Time
+}
+
+// guardCacheSweep is the cache size above which expired answers are dropped.
+const guardCacheSweep = 10000
+
+func NewTokenGuard(
+	users repository.UserRepository,
+	tokens repository.RefreshTokenRepository,
+	cfg TokenGuardConfig,
+) *TokenGuard {
+	return &TokenGuard{
+		users:    users,
+		tokens:   tokens,
+		cfg:      cfg,
+		versions: make(map[string]guardEntry),
+		sessions: make(map[string]guardEntry),
+	}
+}
+
+// Check reports whether an access token of userID, issued to sessionID with
+// token version version, may still be used. Credentials derived from an
+// access token, such as WebSocket tickets, are checked with the values of
+// that token. Tokens from before sessions
+// existed have an empty sessionID and are only checked against the version.
+func (g *TokenGuard) Check(userID, sessionID string, version int) (bool, error) {
+	current, err := g.version(userID)
+	if err != nil {
+		return false, err
+	}
+	if version != current {
+		return false, nil
+	}
+	return g.checkSession(userID, sessionID)
+}
+
+// checkSession reports whether sessionID is still signed in.
+func (g *TokenGuard) checkSession(userID, sessionID string) (bool, error) {
+	if sessionID == "" {
+		return true, nil
+	}
+
+	g.mu.Lock()
+	cached, ok := g.sessions[sessionID]
+	g.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.active, nil
+	}
+
+	active, err := g.tokens.IsFamilyActive(userID, sessionID)
+	if err != nil {
+		return false, err
+	}
+	g.mu.Lock()
+	g.remember(g.sessions, sessionID, guardEntry{active: active})
+	g.mu.Unlock()
+	return active, nil
+}
+
+func (g *TokenGuard) version(userID string) (int, error) {
+	g.mu.Lock()
+	cached, ok := g.versions[userID]
+	g.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.version, nil
+	}
+
+	u, err := g.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			// Deleted users keep no valid tokens.
+			return -1, nil
+		}
+		return 0, err
+	}
+	g.mu.Lock()
+	g.remember(g.versions, userID, guardEntry{version: u.TokenVersion})
+	g.mu.Unlock()
+	return u.TokenVersion, nil
+}
+
+// remember caches e under key, first dropping expired entries once the cache
+// has grown large. g.mu must be held.
+func (g *TokenGuard) remember(cache map[string]guardEntry, key string, e guardEntry) {
+	now := time.Now()
+	if len(cache) >= guardCacheSweep {
+		for k, v := range cache {
+			if !now.Before(v.expires) {
+				delete(cache, k)
+			}
+		}
+	}
+	e.expires = now.Add(g.cfg.CacheTTL)
+	cache[key] = e
+}
+
+// forgetUser drops the cached version after it was bumped.
+func (g *TokenGuard) forgetUser(userID string) {
+	g.mu.Lock()
+	delete(g.versions, userID)
+	g.mu.Unlock()
+}
+
+// forgetSession drops the cached answer after the session was revoked.
+func (g *TokenGuard) forgetSession(sessionID string) {
+	g.mu.Lock()
+	delete(g.sessions, sessionID)
+	g.mu.Unlock()
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"talk-backend/internal/models"
+)
+
+const (
+	aliceID = "3f2504e0-4f89-41d3-9a0c-0305e82c3301"
+	bobID   = "6fa459ea-ee8a-4ca4-894e-db77e160355e"
+)
+
+func TestTokenGuardCheck(t *testing.T) {
+	users := newMemUsers(models.User{ID: aliceID, Email: "alice@example.com", TokenVersion: 2})
+	tokens := newMemTokens(users)
+	now := time.Now()
+	tokens.Create(&models.RefreshToken{UserID: aliceID, FamilyID: "live", ExpiresAt: now.Add(time.Hour)})
+	tokens.Create(&models.RefreshToken{UserID: aliceID, FamilyID: "revoked", ExpiresAt: now.Add(time.Hour), RevokedAt: &now})
+
+	tests := []struct {
+		name      string
+		userID    string
+		sessionID string
+		version   int
+		want      bool
+	}{
+		{name: "live session, current version", userID: aliceID, sessionID: "live", version: 2, want: true},
+		{name: "no session, current version", userID: aliceID, version: 2, want: true},
+		{name: "stale version", userID: aliceID, sessionID: "live", version: 1, want: false},
+		{name: "stale version without session", userID: aliceID, version: 1, want: false},
+		{name: "revoked session", userID: aliceID, sessionID: "revoked", version: 2, want: false},
+		{name: "unknown session", userID: aliceID, sessionID: "other", version: 2, want: false},
+		{name: "deleted user", userID: bobID, version: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewTokenGuard(users, tokens, TokenGuardConfig{CacheTTL: time.Minute})
+			got, err := g.Check(tt.userID, tt.sessionID, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("Check = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A revocation made on this replica must apply at once, cache or not.
+func TestTokenGuardForgetsRevocations(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessionID string
+		revoke    func(s *SessionService) error
+	}{
+		{
+			name:      "logout all, legacy token",
+			sessionID: "",
+			revoke:    func(s *SessionService) error { return s.RevokeAll(aliceID, "", "") },
+		},
+		{
+			name:      "logout all",
+			sessionID: "fam",
+			revoke:    func(s *SessionService) error { return s.RevokeAll(aliceID, "", "") },
+		},
+		{
+			name:      "revoke session",
+			sessionID: "fam",
+			revoke:    func(s *SessionService) error { return s.Revoke(aliceID, "fam", "", "") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newMemUsers(models.User{ID: aliceID, Email: "alice@example.com"})
+			tokens := newMemTokens(users)
+			tokens.Create(&models.RefreshToken{UserID: aliceID, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour)})
+			guard := NewTokenGuard(users, tokens, TokenGuardConfig{CacheTTL: time.Hour})
+			closer := &recordingCloser{}
+			sessions := NewSessionService(users, tokens, &memAudit{}, guard, closer)
+
+			if ok, err := guard.Check(aliceID, tt.sessionID, 0); err != nil || !ok {
+				t.Fatalf("before revocation: Check = %v, %v", ok, err)
+			}
+			if err := tt.revoke(sessions); err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := guard.Check(aliceID, tt.sessionID, 0); err != nil || ok {
+				t.Fatalf("after revocation: Check = %v, %v", ok, err)
+			}
+			if len(closer.sessions)+len(closer.users) == 0 {
+				t.Fatal("open connections were not closed")
+			}
+		})
+	}
+}
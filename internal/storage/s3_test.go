@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Example from the AWS SigV4 documentation.
+func TestSigningKey(t *testing.T) {
+	got := hex.EncodeToString(signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam"))
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got != want {
+		t.Errorf("signingKey = %s, want %s", got, want)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "/bucket/conversations/1/a.png", want: "/bucket/conversations/1/a.png"},
+		{in: "/bucket/a b+c", want: "/bucket/a%20b%2Bc"},
+		{in: "/bucket/~a_b-c.d", want: "/bucket/~a_b-c.d"},
+		{in: "/bucket/é!*", want: "/bucket/%C3%A9%21%2A"},
+	}
+	for _, tt := range tests {
+		if got := escapePath(tt.in); got != tt.want {
+			t.Errorf("escapePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// fakeS3 is a stand-in S3 bucket that checks every request's SigV4
+// signature from what arrived on the wire.
+type fakeS3 struct {
+	accessKey, secretKey, region string
+
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+var authHeader = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/s3/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=([0-9a-f]{64})$`)
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = string(b)
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) has(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[path]
+	return ok
+}
+
+func (f *fakeS3) verify(r *http.Request) error {
+	m := authHeader.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return errors.New("malformed authorization")
+	}
+	accessKey, day, region, signed, signature := m[1], m[2], m[3], m[4], m[5]
+	if accessKey != f.accessKey || region != f.region {
+		return errors.New("wrong credential scope")
+	}
+	amzDate := r.Header.Get("x-amz-date")
+	if !strings.HasPrefix(amzDate, day) {
+		return errors.New("date does not match scope")
+	}
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if r.Method != http.MethodPut && payloadHash != emptyPayloadHash {
+		return errors.New("body hash of an empty request is wrong")
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signed, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	path, query, _ := strings.Cut(r.RequestURI, "?")
+	canonical := strings.Join([]string{r.Method, path, query, headers.String(), signed, payloadHash}, "\n")
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + day + "/" + region + "/s3/aws4_request\n" + hashHex([]byte(canonical))
+	want := hmacSHA256(signingKey(f.secretKey, day, region, "s3"), toSign)
+
+	got, _ := hex.DecodeString(signature)
+	if !hmac.Equal(got, want) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{accessKey: "AKIDEXAMPLE", secretKey: "secret", region: "eu-west-1", objects: make(map[string]string)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	keys := []string{
+		"conversations/1/abc.png",
+		"conversations/1/with space+plus.txt",
+		"conversations/1/ünïcode!.txt",
+	}
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			ctx := context.Background()
+			f, srv := newFakeS3(t)
+			s, err := NewS3Storage(S3Config{Endpoint: srv.URL, Region: f.region, Bucket: "talk", AccessKey: f.accessKey, SecretKey: f.secretKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if !f.has("/talk/" + key) {
+				t.Fatalf("object not stored under /talk/%s", key)
+			}
+
+			rc, err := s.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			body, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(body) != "hello" {
+				t.Errorf("Get = %q, want %q", body, "hello")
+			}
+
+			if err := s.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := s.Get(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("Get after Delete: %v, want ErrObjectNotFound", err)
+			}
+			if err := s.Delete(ctx, key); err != nil {
+				t.Errorf("Delete of a missing object: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3RejectedSignature(t *testing.T) {
+	f, srv := newFakeS3(t)
+	s, err := NewS3Storage(S3Config{Endpoint: srv.URL, Region: f.region, Bucket: "talk", AccessKey: f.accessKey, SecretKey: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put(context.Background(), "a.txt", strings.NewReader("x"), 1, "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put with a wrong secret: %v, want a 403 error", err)
+	}
+}
@@ -0,0 +1,34 @@
+package ws
+
+// Broadcaster carries room messages between hubs. Publish hands msg to every
+// hub attached to the same backplane, this one included, and Messages yields
+// what arrives in publish order. Swapping the implementation lets several API
+// replicas share rooms.
+type Broadcaster interface {
+	Publish(msg RoomMessage) error
+	Messages() <-chan RoomMessage
+	Close() error
+}
+
+// MemoryBroadcaster keeps messages inside the process. It is enough when a
+// single replica serves every socket.
+type MemoryBroadcaster struct {
+	messages chan RoomMessage
+}
+
+func NewMemoryBroadcaster() *MemoryBroadcaster {
+	return &MemoryBroadcaster{messages: make(chan RoomMessage, 256)}
+}
+
+func (b *MemoryBroadcaster) Publish(msg RoomMessage) error {
+	b.messages <- msg
+	return nil
+}
+
+func (b *MemoryBroadcaster) Messages() <-chan RoomMessage {
+	return b.messages
+}
+
+func (b *MemoryBroadcaster) Close() error {
+	return nil
+}
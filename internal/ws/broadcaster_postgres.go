@@ -0,0 +1,234 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	pgChannel = "talk_ws"
+	// PostgreSQL rejects NOTIFY payloads of 8000 bytes or more. Bigger
+	// messages are parked in pgSpillTable and only their row ID is notified.
+	pgMaxPayload = 7900
+	pgSpillTable = "ws_broadcasts"
+	pgSpillTTL   = time.Minute
+)
+
+// pgMessage is the wire form of a RoomMessage. Data is already JSON, so it is
+// embedded as is instead of being base64-encoded.
+type pgMessage struct {
+	RoomID uint            `json:"r,omitempty"`
+	UserID string          `json:"u,omitempty"`
+	Users  []string        `json:"us,omitempty"`
+	Data   json.RawMessage `json:"d,omitempty"`
+	Join   bool            `json:"j,omitempty"`
+	Leave  bool            `json:"l,omitempty"`
+
+	Close     bool   `json:"c,omitempty"`
+	SessionID string `json:"s,omitempty"`
+
+	// Ref points at a row of pgSpillTable holding the full message.
+	Ref int64 `json:"ref,omitempty"`
+}
+
+// PostgresBroadcaster relays room messages through LISTEN/NOTIFY so that
+// every replica connected to the same database sees them. Notifications are
+// not queued while the listener is disconnected; messages published during a
+// reconnect are lost for this replica. Only room messages are shared:
+// presence and connection limits stay per replica.
+type PostgresBroadcaster struct {
+	db       *gorm.DB
+	dsn      string
+	messages chan RoomMessage
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPostgresBroadcaster publishes through db and listens on a dedicated
+// connection opened from dsn, since a pooled connection cannot be held for
+// LISTEN.
+func NewPostgresBroadcaster(db *gorm.DB, dsn string) (*PostgresBroadcaster, error) {
+	err := db.Exec(`CREATE UNLOGGED TABLE IF NOT EXISTS ` + pgSpillTable + ` (
+		id BIGSERIAL PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`).Error
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", pgSpillTable, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := listen(ctx, dsn)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	b := &PostgresBroadcaster{
+		db:       db,
+		dsn:      dsn,
+		messages: make(chan RoomMessage, 256),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go b.run(ctx, conn)
+	return b, nil
+}
+
+func (b *PostgresBroadcaster) Publish(msg RoomMessage) error {
+	payload, err := json.Marshal(pgMessage{
+		RoomID: msg.RoomID,
+		UserID: msg.UserID,
+		Users:  msg.Users,
+		Data:   msg.Data,
+		Join:   msg.Join,
+		Leave:  msg.Leave,
+
+		Close:     msg.Close,
+		SessionID: msg.SessionID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > pgMaxPayload {
+		var id int64
+		err := b.db.Raw(`INSERT INTO `+pgSpillTable+` (payload) VALUES (?) RETURNING id`, string(payload)).Scan(&id).Error
+		if err != nil {
+			return fmt.Errorf("spill broadcast: %w", err)
+		}
+		payload, _ = json.Marshal(pgMessage{Ref: id})
+	}
+
+	return b.db.Exec(`SELECT pg_notify(?, ?)`, pgChannel, string(payload)).Error
+}
+
+func (b *PostgresBroadcaster) Messages() <-chan RoomMessage {
+	return b.messages
+}
+
+func (b *PostgresBroadcaster) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+func listen(ctx context.Context, dsn string) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connect listener: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+pgChannel); err != nil {
+		_ = conn.Close(context.Background())
+		return nil, fmt.Errorf("listen %s: %w", pgChannel, err)
+	}
+	return conn, nil
+}
+
+// run forwards notifications to Messages, reconnecting with backoff when the
+// listener connection drops.
+func (b *PostgresBroadcaster) run(ctx context.Context, conn *pgx.Conn) {
+	defer close(b.done)
+
+	go b.cleanSpill(ctx)
+
+	backoff := time.Second
+	for {
+		if conn == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			var err error
+			if conn, err = listen(ctx, b.dsn); err != nil {
+				log.Printf("[WS] backplane reconnect failed: %v", err)
+				backoff = min(backoff*2, 30*time.Second)
+				continue
+			}
+			log.Println("[WS] backplane reconnected")
+			backoff = time.Second
+		}
+
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			_ = conn.Close(context.Background())
+			conn = nil
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("[WS] backplane listener lost: %v", err)
+			continue
+		}
+
+		msg, err := b.decode(n.Payload)
+		if err != nil {
+			log.Printf("[WS] cannot decode broadcast: %v", err)
+			continue
+		}
+		select {
+		case b.messages <- msg:
+		case <-ctx.Done():
+			_ = conn.Close(context.Background())
+			return
+		}
+	}
+}
+
+func (b *PostgresBroadcaster) decode(payload string) (RoomMessage, error) {
+	var m pgMessage
+	if err := json.Unmarshal([]byte(payload), &m); err != nil {
+		return RoomMessage{}, err
+	}
+	if m.Ref != 0 {
+		var full string
+		err := b.db.Raw(`SELECT payload FROM `+pgSpillTable+` WHERE id = ?`, m.Ref).Scan(&full).Error
+		if err != nil {
+			return RoomMessage{}, err
+		}
+		if full == "" {
+			return RoomMessage{}, fmt.Errorf("spilled broadcast %d is gone", m.Ref)
+		}
+		m = pgMessage{}
+		if err := json.Unmarshal([]byte(full), &m); err != nil {
+			return RoomMessage{}, err
+		}
+	}
+	return RoomMessage{
+		RoomID: m.RoomID,
+		UserID: m.UserID,
+		Users:  m.Users,
+		Data:   []byte(m.Data),
+		Join:   m.Join,
+		Leave:  m.Leave,
+
+		Close:     m.Close,
+		SessionID: m.SessionID,
+	}, nil
+}
+
+// cleanSpill removes spilled messages once every replica has had time to
+// read them.
+func (b *PostgresBroadcaster) cleanSpill(ctx context.Context) {
+	ticker := time.NewTicker(pgSpillTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := b.db.Exec(`DELETE FROM `+pgSpillTable+` WHERE created_at < now() - make_interval(secs => ?)`, pgSpillTTL.Seconds()).Error
+			if err != nil {
+				log.Printf("[WS] cannot clean %s: %v", pgSpillTable, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package ws
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testPostgres connects to the database named by TEST_DATABASE_DSN, e.g.
+// "host=localhost user=postgres dbname=talk_test sslmode=disable", and skips
+// the test when it is not set.
+func testPostgres(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, dsn
+}
+
+func newTestPostgresBroadcaster(t *testing.T, db *gorm.DB, dsn string) *PostgresBroadcaster {
+	t.Helper()
+	b, err := NewPostgresBroadcaster(db, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// Two hubs in one process stand in for two replicas sharing the database.
+func TestPostgresBroadcasterSharesRooms(t *testing.T) {
+	db, dsn := testPostgres(t)
+	const (
+		room uint = 7
+		user      = "u1"
+	)
+	tests := []struct {
+		name  string
+		event string
+	}{
+		{name: "notified inline", event: "hello"},
+		{name: "spilled to table", event: strings.Repeat("x", 2*pgMaxPayload)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := NewHub(newTestPostgresBroadcaster(t, db, dsn))
+			go sender.Run()
+			defer sender.GoAway()
+			receiver := NewHub(newTestPostgresBroadcaster(t, db, dsn))
+			go receiver.Run()
+			defer receiver.GoAway()
+
+			c := newTestClient(t, receiver, user, 0, room)
+			sender.PublishToConversation(room, tt.event)
+			sender.PublishToUsers([]string{user}, "end")
+
+			got := received(t, c)
+			if len(got) != 1 || got[0] != `"`+tt.event+`"` {
+				t.Fatalf("got %d frames, want the event once", len(got))
+			}
+		})
+	}
+}
+
+func TestPostgresBroadcasterReconnects(t *testing.T) {
+	db, dsn := testPostgres(t)
+	b := newTestPostgresBroadcaster(t, db, dsn)
+
+	var killed []bool
+	err := db.Raw(`SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+		WHERE query = ? AND pid <> pg_backend_pid()`, "LISTEN "+pgChannel).Scan(&killed).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(killed) == 0 {
+		t.Fatal("listener connection not found")
+	}
+
+	// Messages published while the listener is down are lost, so keep
+	// publishing until one makes it through the new connection.
+	deadline := time.After(15 * time.Second)
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case msg := <-b.Messages():
+			if msg.RoomID == 9 {
+				return
+			}
+		case <-tick.C:
+			if err := b.Publish(RoomMessage{RoomID: 9, Data: []byte(`"ping"`)}); err != nil {
+				t.Fatal(err)
+			}
+		case <-deadline:
+			t.Fatal("no message received after the listener was terminated")
+		}
+	}
+}
@@ -1,17 +1,50 @@
 package ws
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// lastClientID numbers connections so a user's devices can be told apart.
+var lastClientID atomic.Uint64
+
 type Client struct {
+	id uint64
+	// conn is nil for SSE clients, which drain send themselves.
 	conn   *websocket.Conn
 	hub    *Hub
 	send   chan []byte
 	userID string
-	roomID uint
+	// sessionID is the session the connection was authenticated with, empty
+	// for tokens issued before sessions existed.
+	sessionID string
+
+	// protocol is the negotiated subprotocol, empty for bare frames.
+	protocol string
+	// boundTo limits the connection to one conversation when its ticket was
+	// issued for it.
+	boundTo uint
+
+	// rooms is owned by the hub goroutine once the client is registered.
+	rooms map[uint]bool
+
+	// replaying is set before registration when the connection starts with a
+	// replay. Until the hub's endReplay, live frames are kept in held instead
+	// of send, which nobody drains yet. Both are owned by the hub goroutine.
+	replaying bool
+	held      [][]byte
+
+	// closeFrame is the payload of the close frame sent once send is closed;
+	// the hub sets it before closing send.
+	closeFrame []byte
+	// done is closed when the writer of this client has finished.
+	done chan struct{}
+}
+
+func (c *Client) inScope(conversationID uint) bool {
+	return c.boundTo == 0 || c.boundTo == conversationID
 }
 
 const (
@@ -25,6 +58,7 @@ func (c *Client) writePump() {
 	defer func() {
 		ticker.Stop()
 		_ = c.conn.Close()
+		close(c.done)
 	}()
 
 	for {
@@ -32,7 +66,7 @@ func (c *Client) writePump() {
 		case msg, ok := <-c.send:
 			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				_ = c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame)
 				return
 			}
 			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
@@ -1,183 +1,509 @@
 package ws
 
 import (
-	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"talk-backend/internal/auth"
 	"talk-backend/internal/http/response"
+	"talk-backend/internal/models"
+	"talk-backend/internal/repository"
 	"talk-backend/internal/service"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
 )
 
 type WSHandler struct {
-	hub       *Hub
-	chat      *service.ChatService
-	jwtSecret string
+	hub      *Hub
+	chat     *service.ChatService
+	presence *service.PresenceService
+	tickets  *service.TicketService
+	guard    *service.TokenGuard
+	verifier *auth.Verifier
+
+	cfg      Config
+	upgrader websocket.Upgrader
+	conns    *connLimiter
 }
 
-func NewWSHandler(hub *Hub, chat *service.ChatService, jwtSecret string) *WSHandler {
-	return &WSHandler{hub: hub, chat: chat, jwtSecret: jwtSecret}
-}
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true }, // en prod: restreindre
+func NewWSHandler(hub *Hub, chat *service.ChatService, presence *service.PresenceService, tickets *service.TicketService, guard *service.TokenGuard, verifier *auth.Verifier, cfg Config) *WSHandler {
+	return &WSHandler{
+		hub:      hub,
+		chat:     chat,
+		presence: presence,
+		tickets:  tickets,
+		guard:    guard,
+		verifier: verifier,
+		cfg:      cfg,
+		upgrader: websocket.Upgrader{
+			CheckOrigin:  checkOrigin(cfg.AllowedOrigins),
+			Subprotocols: []string{Subprotocol},
+		},
+		conns: newConnLimiter(cfg.MaxConnsPerUser, cfg.MaxConnsPerIP),
+	}
 }
 
-var uuidV4LikeRe = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[1-5][a-fA-F0-9]{3}-[89abAB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$`)
-
-type InMessage struct {
-	Type           string `json:"type"`
-	ConversationID uint   `json:"conversationId"`
-	Content        string `json:"content,omitempty"`
-	IsTyping       *bool  `json:"isTyping,omitempty"`
-}
+const (
+	resumeWait  = 10 * time.Second
+	resumeLimit = 100
+	maxClientID = 64
+	// authGrace absorbs clock skew and the round trip of a token refresh.
+	authGrace = 30 * time.Second
+)
 
+// Handle upgrades to a WebSocket subscribed to every conversation of the
+// user. Browsers authenticate with ?ticket= from POST /api/ws/ticket; other
+// clients may send an Authorization header. Either way the socket lives only
+// as long as the access token behind it: clients send "auth" frames with
+// refreshed tokens, and a socket whose session was revoked, and so cannot
+// refresh, is closed with CloseAuthExpired.
+// Clients add or drop conversations with subscribe/unsubscribe frames.
+// Passing conversationId keeps the older one-conversation-per-socket mode.
+//
+// Clients offering the talk.v1 subprotocol exchange Envelope frames; others
+// send and receive the bare payloads. Every rejected request is answered with
+// an "error" frame.
+//
+// With resume=true the first frame must be a "resume" frame listing the last
+// message ID seen per conversation. The missed messages are replayed, then a
+// "resumed" frame is sent, and only then does live traffic start. Messages
+// stored while the replay runs may arrive twice; clients dedupe on ID.
 func (h *WSHandler) Handle(c *gin.Context) {
-	convStr := c.Query("conversationId")
-	conv64, err := strconv.ParseUint(convStr, 10, 64)
-	if err != nil || conv64 == 0 {
-		response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgConversationRequired)
+	var (
+		userID        string
+		sessionID     string
+		authExpiresAt time.Time
+		boundTo       uint
+		live          bool
+		err           error
+	)
+	if raw := c.Query("ticket"); raw != "" {
+		var t *models.WSTicket
+		if t, err = h.tickets.Redeem(raw); err != nil {
+			if errors.Is(err, service.ErrInvalidTicket) {
+				response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgInvalidTicket)
+				return
+			}
+			response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgInternalServer)
+			return
+		}
+		userID, sessionID, authExpiresAt = t.UserID, t.SessionID, t.AuthExpiresAt
+		if t.ConversationID != nil {
+			boundTo = *t.ConversationID
+		}
+		// The token the ticket was bought with was checked then; its session
+		// or the user's token version may have been revoked since.
+		live, err = h.guard.Check(userID, sessionID, t.TokenVersion)
+	} else {
+		var p *auth.Principal
+		if p, err = h.verifier.Verify(bearer(c.GetHeader("Authorization"))); err != nil {
+			response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+			return
+		}
+		userID, sessionID, authExpiresAt = p.UserID, p.SessionID, p.ExpiresAt
+		live, err = h.guard.Check(p.UserID, p.SessionID, p.Version)
+	}
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgInternalServer)
 		return
 	}
-	roomID := uint(conv64)
-
-	userID, ok := h.extractUserID(c.GetHeader("Authorization"))
-	if !ok {
+	if !live {
 		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
 		return
 	}
 
-	_, checkErr := h.chat.GetMessages(userID, roomID, 1, nil)
-	if checkErr == service.ErrForbidden {
-		response.Error(c, http.StatusForbidden, response.CodeForbidden, response.MsgForbidden)
-		return
+	roomID := boundTo
+	if convStr := c.Query("conversationId"); convStr != "" {
+		conv64, err := strconv.ParseUint(convStr, 10, 64)
+		if err != nil || conv64 == 0 {
+			response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidConversation)
+			return
+		}
+		if boundTo != 0 && uint(conv64) != boundTo {
+			response.Error(c, http.StatusForbidden, response.CodeForbidden, response.MsgForbidden)
+			return
+		}
+		roomID = uint(conv64)
 	}
-	if checkErr != nil && checkErr != service.ErrForbidden {
-		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgInternalServer)
+
+	var rooms []uint
+	if roomID != 0 {
+		if err := h.chat.CheckAccess(userID, roomID); err != nil {
+			if errors.Is(err, service.ErrForbidden) {
+				response.Error(c, http.StatusForbidden, response.CodeForbidden, response.MsgForbidden)
+				return
+			}
+			response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgInternalServer)
+			return
+		}
+		rooms = []uint{roomID}
+	} else {
+		ids, err := h.chat.ConversationIDs(userID)
+		if err != nil {
+			response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgInternalServer)
+			return
+		}
+		rooms = ids
+	}
+
+	ip := c.ClientIP()
+	if !h.conns.acquire(userID, ip) {
+		response.Error(c, http.StatusTooManyRequests, response.CodeTooManyRequests, response.MsgTooManyConnections)
 		return
 	}
+	defer h.conns.release(userID, ip)
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
 	}
+	if h.cfg.MaxMessageSize > 0 {
+		conn.SetReadLimit(h.cfg.MaxMessageSize)
+	}
 
 	client := &Client{
-		conn:   conn,
-		hub:    h.hub,
-		send:   make(chan []byte, 64),
-		userID: userID,
-		roomID: roomID,
+		id:        lastClientID.Add(1),
+		conn:      conn,
+		hub:       h.hub,
+		send:      make(chan []byte, 64),
+		done:      make(chan struct{}),
+		userID:    userID,
+		sessionID: sessionID,
+		rooms:     make(map[uint]bool, len(rooms)),
+		protocol:  conn.Subprotocol(),
+		boundTo:   boundTo,
+	}
+	for _, id := range rooms {
+		client.rooms[id] = true
 	}
 
+	var lastSeen map[uint]uint
+	if c.Query("resume") == "true" {
+		lastSeen = readResume(conn, client.protocol)
+	}
+	_ = conn.SetReadDeadline(authExpiresAt.Add(authGrace))
+
+	// Registering before the replay means nothing published meanwhile is
+	// lost: the hub holds live frames back until the replay is written, so a
+	// slow replay cannot overflow client.send.
+	client.replaying = lastSeen != nil
 	h.hub.register <- client
+	if lastSeen != nil {
+		h.replay(client, rooms, lastSeen)
+		for _, data := range h.hub.endReplay(client) {
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if conn.WriteMessage(websocket.TextMessage, data) != nil {
+				break
+			}
+		}
+	}
 	go client.writePump()
 
-	lastTyping := time.Time{}
+	lastTyping := make(map[uint]time.Time)
+	frames := rate.NewLimiter(rate.Limit(h.cfg.FrameRate), h.cfg.FrameBurst)
+	if h.cfg.FrameRate <= 0 {
+		frames.SetLimit(rate.Inf)
+	}
 
 	for {
 		_, p, err := conn.ReadMessage()
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				closeWith(conn, CloseAuthExpired, response.MsgTokenExpired)
+			}
 			break
 		}
-
-		var in InMessage
-		if err := json.Unmarshal(p, &in); err != nil {
-			continue
+		if !frames.Allow() {
+			closeWith(conn, websocket.ClosePolicyViolation, response.MsgTooManyFrames)
+			break
 		}
 
-		if in.ConversationID != roomID {
+		in, err := parseFrame(client.protocol, p)
+		if err != nil {
+			h.reply(client, in.ID, errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame))
 			continue
 		}
+		if out := h.dispatch(client, in, lastTyping); out != nil {
+			h.reply(client, in.ID, out)
+		}
+	}
 
-		if in.Type == "typing" && in.IsTyping != nil {
-			if time.Since(lastTyping) < 500*time.Millisecond {
-				continue
-			}
-			lastTyping = time.Now()
+	h.hub.unregister <- client
+	_ = conn.Close()
+}
 
-			outTyping, _ := json.Marshal(gin.H{
-				"type":           "typing",
-				"conversationId": roomID,
-				"userId":         userID,
-				"isTyping":       *in.IsTyping,
-			})
+// dispatch runs one client request and returns the frame answering it, or
+// nil when there is nothing to say. Frames carrying an envelope ID are acked;
+// sent messages always are.
+func (h *WSHandler) dispatch(client *Client, in inbound, lastTyping map[uint]time.Time) any {
+	userID := client.userID
+
+	switch in.Type {
+	case FrameSubscribe, FrameUnsubscribe:
+		var p SubscribePayload
+		if err := in.decode(&p); err != nil {
+			return errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame)
+		}
+		if !client.inScope(p.ConversationID) {
+			return conversationError(service.ErrForbidden, p.ConversationID, "", "")
+		}
+		if in.Type == FrameUnsubscribe {
+			h.hub.unsubscribe <- clientRoom{client: client, roomID: p.ConversationID}
+			return ackIfAsked(in, AckEvent{ConversationID: p.ConversationID})
+		}
+		if err := h.chat.CheckAccess(userID, p.ConversationID); err != nil {
+			return conversationError(err, p.ConversationID, response.CodeInternal, response.MsgInternalServer)
+		}
+		h.hub.subscribe <- clientRoom{client: client, roomID: p.ConversationID}
+		return ackIfAsked(in, AckEvent{ConversationID: p.ConversationID})
 
-			h.hub.broadcast <- RoomMessage{RoomID: roomID, Data: outTyping}
-			continue
+	case FrameTyping:
+		var p TypingPayload
+		if err := in.decode(&p); err != nil {
+			return errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame)
 		}
+		if !client.inScope(p.ConversationID) {
+			return conversationError(service.ErrForbidden, p.ConversationID, "", "")
+		}
+		// Bursts are dropped quietly; the next change goes through.
+		if time.Since(lastTyping[p.ConversationID]) < 500*time.Millisecond {
+			return nil
+		}
+		if err := h.chat.CheckAccess(userID, p.ConversationID); err != nil {
+			return conversationError(err, p.ConversationID, response.CodeInternal, response.MsgInternalServer)
+		}
+		lastTyping[p.ConversationID] = time.Now()
 
-		if in.Type != "message" || strings.TrimSpace(in.Content) == "" {
-			continue
+		h.hub.publish(p.ConversationID, "", TypingEvent{
+			Type:           FrameTyping,
+			ConversationID: p.ConversationID,
+			UserID:         userID,
+			IsTyping:       *p.IsTyping,
+		})
+		return ackIfAsked(in, AckEvent{ConversationID: p.ConversationID})
+
+	case service.EventDelivered, service.EventRead:
+		var p ReceiptPayload
+		if err := in.decode(&p); err != nil {
+			return errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame)
 		}
+		if !client.inScope(p.ConversationID) {
+			return conversationError(service.ErrForbidden, p.ConversationID, "", "")
+		}
+		mark, failure := h.chat.MarkDelivered, response.MsgInternalServer
+		if in.Type == service.EventRead {
+			mark, failure = h.chat.MarkRead, response.MsgMarkRead
+		}
+		if err := mark(userID, p.ConversationID, p.MessageID); err != nil {
+			return conversationError(err, p.ConversationID, response.CodeMessageFailed, failure)
+		}
+		return ackIfAsked(in, AckEvent{ConversationID: p.ConversationID, MessageID: p.MessageID})
+
+	case service.EventMessage:
+		var p SendMessagePayload
+		if err := in.decode(&p); err != nil {
+			ev := errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame)
+			ev.ClientMessageID = p.ClientMessageID
+			ev.ConversationID = p.ConversationID
+			return ev
+		}
+		if !client.inScope(p.ConversationID) {
+			ev := conversationError(service.ErrForbidden, p.ConversationID, "", "")
+			ev.ClientMessageID = p.ClientMessageID
+			return ev
+		}
+
+		msg, err := h.chat.SendMessage(userID, p.ConversationID, service.NewMessage{
+			Content:       p.Content,
+			ReplyToID:     p.ReplyTo,
+			ThreadRootID:  p.ThreadRoot,
+			AttachmentIDs: p.AttachmentIDs,
 
-		msg, err := h.chat.SendMessage(userID, roomID, in.Content)
+			ClientMessageID: p.ClientMessageID,
+		})
 		if err != nil {
-			continue
+			ev := conversationError(err, p.ConversationID, response.CodeMessageFailed, response.MsgSendMessage)
+			ev.ClientMessageID = p.ClientMessageID
+			return ev
+		}
+		return AckEvent{
+			Type:            FrameAck,
+			ClientMessageID: p.ClientMessageID,
+			ConversationID:  p.ConversationID,
+			MessageID:       msg.ID,
 		}
 
-		out, _ := json.Marshal(gin.H{
-			"type": "message",
-			"message": gin.H{
-				"id":             msg.ID,
-				"conversationId": msg.ConversationID,
-				"senderId":       msg.SenderID,
-				"content":        msg.Content,
-				"sentAt":         msg.SentAt,
-			},
-		})
+	case FramePresence:
+		var p PresencePayload
+		if err := in.decode(&p); err != nil {
+			return errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame)
+		}
+		h.presence.SetAway(userID, client.id, p.Status == service.PresenceAway)
+		return ackIfAsked(in, AckEvent{})
+
+	case FrameAuth:
+		var p AuthPayload
+		if err := in.decode(&p); err != nil {
+			return errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame)
+		}
+		principal, err := h.verifier.Verify(p.Token)
+		if err != nil || principal.UserID != userID || (client.sessionID != "" && principal.SessionID != client.sessionID) {
+			return ErrorEvent{Type: FrameError, Code: response.CodeUnauthorized, Message: response.MsgUnauthorized}
+		}
+		if live, err := h.guard.Check(principal.UserID, principal.SessionID, principal.Version); err != nil || !live {
+			return ErrorEvent{Type: FrameError, Code: response.CodeUnauthorized, Message: response.MsgUnauthorized}
+		}
+		_ = client.conn.SetReadDeadline(principal.ExpiresAt.Add(authGrace))
+		return ackIfAsked(in, AckEvent{})
 
-		h.hub.broadcast <- RoomMessage{RoomID: roomID, Data: out}
+	default:
+		return errorEvent(errInvalidFrame, response.CodeInvalidRequest, response.MsgInvalidFrame)
 	}
+}
 
-	h.hub.unregister <- client
-	_ = conn.Close()
+// reply queues event for this connection only.
+func (h *WSHandler) reply(client *Client, id string, event any) {
+	data, err := encodeFrame(client.protocol, id, event)
+	if err != nil {
+		log.Printf("[WS] cannot encode frame: %v", err)
+		return
+	}
+	h.hub.sendTo(client, data)
 }
 
-func (h *WSHandler) extractUserID(authHeader string) (string, bool) {
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
-		return "", false
+func ackIfAsked(in inbound, ack AckEvent) any {
+	if in.ID == "" {
+		return nil
 	}
+	ack.Type = FrameAck
+	return ack
+}
 
-	tok, err := jwt.Parse(parts[1], func(t *jwt.Token) (any, error) {
-		if t.Method != jwt.SigningMethodHS256 {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return []byte(h.jwtSecret), nil
-	})
-	if err != nil || tok == nil || !tok.Valid {
-		return "", false
+// readResume waits for the handshake frame. A missing or malformed one is
+// reported and the connection goes on without a replay. The caller resets
+// the read deadline afterwards.
+func readResume(conn *websocket.Conn, protocol string) map[uint]uint {
+	_ = conn.SetReadDeadline(time.Now().Add(resumeWait))
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil
 	}
 
-	claims, ok := tok.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", false
+	in, err := parseFrame(protocol, data)
+	if err == nil && in.Type != FrameResume {
+		err = errInvalidFrame
+	}
+	var p ResumePayload
+	if err == nil {
+		err = in.decode(&p)
 	}
+	if err != nil {
+		writeFrame(conn, protocol, in.ID, errorEvent(err, response.CodeInvalidRequest, response.MsgInvalidFrame))
+		return nil
+	}
+	if p.LastSeen == nil {
+		return map[uint]uint{}
+	}
+	return p.LastSeen
+}
 
-	sub, ok := claims["sub"]
-	if !ok {
-		return "", false
+// replay writes the messages missed in each conversation straight to the
+// connection. It runs before writePump starts, so it is the only writer.
+func (h *WSHandler) replay(client *Client, rooms []uint, lastSeen map[uint]uint) {
+	subscribed := make(map[uint]bool, len(rooms))
+	for _, id := range rooms {
+		subscribed[id] = true
 	}
 
-	switch v := sub.(type) {
-	case string:
-		if !isUUID(v) {
-			return "", false
+	done := ResumedEvent{Type: FrameResumed, Conversations: []ResumedConversation{}}
+	for convID, afterID := range lastSeen {
+		if !subscribed[convID] {
+			writeFrame(client.conn, client.protocol, "", conversationError(service.ErrForbidden, convID, "", ""))
+			continue
 		}
-		return v, true
-	default:
-		return "", false
+
+		msgs, err := h.chat.MissedMessages(client.userID, convID, afterID, resumeLimit+1)
+		if err != nil {
+			writeFrame(client.conn, client.protocol, "", conversationError(err, convID, response.CodeMessageFailed, response.MsgGetMessages))
+			continue
+		}
+
+		hasMore := len(msgs) > resumeLimit
+		if hasMore {
+			msgs = msgs[:resumeLimit]
+		}
+		for i := range msgs {
+			if !writeFrame(client.conn, client.protocol, "", service.MessageEvent{
+				Type:           service.EventMessage,
+				ConversationID: convID,
+				Message:        service.NewMessagePayload(&msgs[i]),
+			}) {
+				return
+			}
+		}
+		done.Conversations = append(done.Conversations, ResumedConversation{ConversationID: convID, HasMore: hasMore})
 	}
+	writeFrame(client.conn, client.protocol, "", done)
 }
 
-func isUUID(v string) bool {
-	return uuidV4LikeRe.MatchString(v)
+// closeWith sends a close frame. WriteControl may run alongside writePump.
+func closeWith(conn *websocket.Conn, code int, reason string) {
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeWait))
+}
+
+func writeFrame(conn *websocket.Conn, protocol string, id string, event any) bool {
+	data, err := encodeFrame(protocol, id, event)
+	if err != nil {
+		return false
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(websocket.TextMessage, data) == nil
+}
+
+// errorEvent maps err to the codes of the HTTP API, falling back to code and
+// message for unexpected errors.
+func errorEvent(err error, code, message string) ErrorEvent {
+	var perr *protocolError
+	switch {
+	case errors.As(err, &perr):
+		code, message = perr.Code, perr.Message
+	case errors.Is(err, service.ErrForbidden):
+		code, message = response.CodeForbidden, response.MsgForbidden
+	case errors.Is(err, service.ErrEmptyMessage):
+		code, message = response.CodeInvalidRequest, response.MsgEmptyMessage
+	case errors.Is(err, service.ErrInvalidReference):
+		code, message = response.CodeInvalidRequest, response.MsgInvalidReference
+	case errors.Is(err, service.ErrClientIDReused):
+		code, message = response.CodeClientIDReused, response.MsgClientIDReused
+	case errors.Is(err, repository.ErrMessageNotFound):
+		code, message = response.CodeNotFound, response.MsgMessageNotFound
+	case errors.Is(err, repository.ErrAttachmentNotFound):
+		code, message = response.CodeNotFound, response.MsgAttachmentNotFound
+	}
+	return ErrorEvent{Type: FrameError, Code: code, Message: message}
+}
+
+func conversationError(err error, conversationID uint, code, message string) ErrorEvent {
+	ev := errorEvent(err, code, message)
+	ev.ConversationID = conversationID
+	return ev
+}
+
+func bearer(authHeader string) string {
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return ""
+	}
+	return parts[1]
 }
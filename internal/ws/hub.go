@@ -1,61 +1,396 @@
 package ws
 
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"talk-backend/internal/http/response"
+
+	"github.com/gorilla/websocket"
+)
+
 type Hub struct {
 	// roomID -> clients
 	rooms map[uint]map[*Client]bool
+	// userID -> clients, one per open connection
+	users map[string]map[*Client]bool
+
+	register    chan *Client
+	unregister  chan *Client
+	subscribe   chan clientRoom
+	unsubscribe chan clientRoom
+	direct      chan clientFrame
+	replayed    chan replayDone
+	broadcaster Broadcaster
+
+	presence PresenceTracker
+
+	// stopping is closed by GoAway; drained once every connection open at
+	// that point has finished writing. closing is owned by Run.
+	stopping chan struct{}
+	stopOnce sync.Once
+	drained  chan struct{}
+	closing  bool
+}
 
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan RoomMessage
+// PresenceTracker is told about every connection the hub accepts or drops.
+// Calls happen on the hub goroutine and must not block.
+type PresenceTracker interface {
+	Connected(userID string, connID uint64)
+	Disconnected(userID string, connID uint64)
 }
 
 type RoomMessage struct {
 	RoomID uint
+	// UserID, when set, restricts delivery to that user's clients in the room.
+	UserID string
 	Data   []byte
+
+	// Users, when set, delivers Data to every client of these users instead
+	// of a room.
+	Users []string
+
+	// Join and Leave subscribe or unsubscribe every client of UserID to the
+	// room instead of delivering Data. They travel through the broadcaster so
+	// they stay ordered with the events around them.
+	Join  bool
+	Leave bool
+
+	// Close closes connections of UserID instead of delivering Data: those
+	// opened with SessionID, or every one when SessionID is empty.
+	Close     bool
+	SessionID string
+}
+
+type clientRoom struct {
+	client *Client
+	roomID uint
+}
+
+// clientFrame is a reply meant for one connection only, such as an ack.
+type clientFrame struct {
+	client *Client
+	data   []byte
 }
 
-func NewHub() *Hub {
+// replayDone asks the hub to resume live delivery to a client and to hand
+// back the frames it held meanwhile.
+type replayDone struct {
+	client *Client
+	held   chan [][]byte
+}
+
+// maxHeld bounds the frames held back during a replay. A connection that
+// falls further behind is dropped like any slow one.
+const maxHeld = 1024
+
+func NewHub(broadcaster Broadcaster) *Hub {
 	return &Hub{
-		rooms:      make(map[uint]map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan RoomMessage, 256),
+		rooms:       make(map[uint]map[*Client]bool),
+		users:       make(map[string]map[*Client]bool),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		subscribe:   make(chan clientRoom),
+		unsubscribe: make(chan clientRoom),
+		direct:      make(chan clientFrame),
+		replayed:    make(chan replayDone),
+		broadcaster: broadcaster,
+		stopping:    make(chan struct{}),
+		drained:     make(chan struct{}),
 	}
 }
 
+// SetPresence must be called before Run.
+func (h *Hub) SetPresence(p PresenceTracker) {
+	h.presence = p
+}
+
+// Run serves the hub until the process exits. After GoAway it keeps running
+// so late unregisters do not block, but refuses every new connection.
 func (h *Hub) Run() {
+	stopping := h.stopping
 	for {
 		select {
+		case <-stopping:
+			stopping = nil
+			h.goAway()
+
 		case c := <-h.register:
-			if h.rooms[c.roomID] == nil {
-				h.rooms[c.roomID] = make(map[*Client]bool)
+			if h.closing {
+				c.closeFrame = goingAway
+				close(c.send)
+				continue
+			}
+			if h.users[c.userID] == nil {
+				h.users[c.userID] = make(map[*Client]bool)
+			}
+			h.users[c.userID][c] = true
+			for roomID := range c.rooms {
+				h.join(roomID, c)
+			}
+			if h.presence != nil {
+				h.presence.Connected(c.userID, c.id)
 			}
-			h.rooms[c.roomID][c] = true
 
 		case c := <-h.unregister:
-			if h.rooms[c.roomID] != nil {
-				if _, ok := h.rooms[c.roomID][c]; ok {
-					delete(h.rooms[c.roomID], c)
-					close(c.send)
-					if len(h.rooms[c.roomID]) == 0 {
-						delete(h.rooms, c.roomID)
-					}
-				}
-			}
+			h.remove(c)
 
-		case msg := <-h.broadcast:
-			if h.rooms[msg.RoomID] == nil {
-				continue
+		case cr := <-h.subscribe:
+			if h.users[cr.client.userID][cr.client] {
+				h.join(cr.roomID, cr.client)
 			}
-			for c := range h.rooms[msg.RoomID] {
+
+		case cr := <-h.unsubscribe:
+			h.leave(cr.roomID, cr.client)
+
+		case f := <-h.direct:
+			if h.users[f.client.userID][f.client] {
 				select {
-				case c.send <- msg.Data:
+				case f.client.send <- f.data:
 				default:
-					// client trop lent -> drop
-					delete(h.rooms[msg.RoomID], c)
-					close(c.send)
+					h.remove(f.client)
 				}
 			}
+
+		case r := <-h.replayed:
+			r.client.replaying = false
+			r.held <- r.client.held
+			r.client.held = nil
+
+		case msg := <-h.broadcaster.Messages():
+			switch {
+			case msg.Join:
+				for c := range h.users[msg.UserID] {
+					// Sockets bound to one conversation stay out of new ones.
+					if c.inScope(msg.RoomID) {
+						h.join(msg.RoomID, c)
+					}
+				}
+			case msg.Leave:
+				for c := range h.users[msg.UserID] {
+					h.leave(msg.RoomID, c)
+				}
+			case msg.Close:
+				for c := range h.users[msg.UserID] {
+					if msg.SessionID == "" || c.sessionID == msg.SessionID {
+						c.closeFrame = sessionRevoked
+						h.remove(c)
+					}
+				}
+			case msg.Users != nil:
+				h.deliverToUsers(msg)
+			default:
+				h.deliver(msg)
+			}
+		}
+	}
+}
+
+var (
+	goingAway      = websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	sessionRevoked = websocket.FormatCloseMessage(CloseSessionRevoked, response.MsgSessionRevoked)
+)
+
+// GoAway starts closing every connection with 1001 (going away) so clients
+// reconnect to another replica. It does not wait; see Shutdown.
+func (h *Hub) GoAway() {
+	h.stopOnce.Do(func() { close(h.stopping) })
+}
+
+// Shutdown calls GoAway and waits until every connection has flushed its
+// queued frames and close frame, or ctx is done.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.GoAway()
+	select {
+	case <-h.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hub) goAway() {
+	h.closing = true
+	var pending []chan struct{}
+	for _, clients := range h.users {
+		for c := range clients {
+			c.closeFrame = goingAway
+			pending = append(pending, c.done)
+			h.remove(c)
 		}
 	}
+	log.Printf("[WS] closing %d connections", len(pending))
+
+	go func() {
+		for _, done := range pending {
+			<-done
+		}
+		close(h.drained)
+	}()
+}
+
+func (h *Hub) deliver(msg RoomMessage) {
+	var wrapped []byte
+	for c := range h.rooms[msg.RoomID] {
+		if msg.UserID != "" && c.userID != msg.UserID {
+			continue
+		}
+		h.push(c, msg.Data, &wrapped)
+	}
+}
+
+func (h *Hub) deliverToUsers(msg RoomMessage) {
+	var wrapped []byte
+	for _, userID := range msg.Users {
+		for c := range h.users[userID] {
+			h.push(c, msg.Data, &wrapped)
+		}
+	}
+}
+
+// push queues data for c in the client's protocol. The envelope is built once
+// per message and shared through wrapped.
+func (h *Hub) push(c *Client, data []byte, wrapped *[]byte) {
+	if c.protocol == Subprotocol {
+		if *wrapped == nil {
+			*wrapped = wrap(data, "")
+		}
+		data = *wrapped
+	}
+	if c.replaying {
+		if len(c.held) >= maxHeld {
+			h.remove(c)
+			return
+		}
+		c.held = append(c.held, data)
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		// client trop lent -> drop
+		h.remove(c)
+	}
+}
+
+func (h *Hub) join(roomID uint, c *Client) {
+	if h.rooms[roomID] == nil {
+		h.rooms[roomID] = make(map[*Client]bool)
+	}
+	h.rooms[roomID][c] = true
+	c.rooms[roomID] = true
+}
+
+func (h *Hub) leave(roomID uint, c *Client) {
+	delete(c.rooms, roomID)
+	if h.rooms[roomID] == nil {
+		return
+	}
+	delete(h.rooms[roomID], c)
+	if len(h.rooms[roomID]) == 0 {
+		delete(h.rooms, roomID)
+	}
+}
+
+// remove drops the client from every index and closes its send channel,
+// which makes writePump close the connection.
+func (h *Hub) remove(c *Client) {
+	if !h.users[c.userID][c] {
+		return
+	}
+	for roomID := range c.rooms {
+		h.leave(roomID, c)
+	}
+	delete(h.users[c.userID], c)
+	if len(h.users[c.userID]) == 0 {
+		delete(h.users, c.userID)
+	}
+	close(c.send)
+	if h.presence != nil {
+		h.presence.Disconnected(c.userID, c.id)
+	}
+}
+
+// PublishToConversation encodes event as JSON and broadcasts it to the room.
+func (h *Hub) PublishToConversation(conversationID uint, event any) {
+	h.publish(conversationID, "", event)
+}
+
+// PublishToMember sends event only to userID's own clients in the room.
+func (h *Hub) PublishToMember(conversationID uint, userID string, event any) {
+	h.publish(conversationID, userID, event)
+}
+
+func (h *Hub) publish(roomID uint, userID string, event any) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[WS] cannot encode event: %v", err)
+		return
+	}
+	h.broadcast(RoomMessage{RoomID: roomID, UserID: userID, Data: data})
+}
+
+// endReplay resumes live delivery to a client registered with replaying set
+// and returns the frames held back meanwhile, which the caller writes before
+// anything queued in send.
+func (h *Hub) endReplay(c *Client) [][]byte {
+	r := replayDone{client: c, held: make(chan [][]byte, 1)}
+	h.replayed <- r
+	return <-r.held
+}
+
+// sendTo queues an encoded frame for a single connection. The hub owns the
+// send channel, so replies go through it rather than being written to the
+// client directly.
+func (h *Hub) sendTo(c *Client, data []byte) {
+	h.direct <- clientFrame{client: c, data: data}
+}
+
+// broadcast hands msg to the broadcaster, which brings it back to this hub's
+// Run loop and to every other hub sharing the backplane.
+func (h *Hub) broadcast(msg RoomMessage) {
+	if err := h.broadcaster.Publish(msg); err != nil {
+		log.Printf("[WS] cannot broadcast to room %d: %v", msg.RoomID, err)
+	}
+}
+
+// SubscribeToConversation adds the room to every open connection of userID,
+// e.g. when they are added to a group.
+func (h *Hub) SubscribeToConversation(conversationID uint, userID string) {
+	h.broadcast(RoomMessage{RoomID: conversationID, UserID: userID, Join: true})
+}
+
+// UnsubscribeFromConversation stops delivering the room's events to userID.
+// Their connections stay open for their other conversations.
+func (h *Hub) UnsubscribeFromConversation(conversationID uint, userID string) {
+	h.broadcast(RoomMessage{RoomID: conversationID, UserID: userID, Leave: true})
+}
+
+// PublishToUsers sends event to every open connection of the given users.
+func (h *Hub) PublishToUsers(userIDs []string, event any) {
+	if len(userIDs) == 0 {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[WS] cannot encode event: %v", err)
+		return
+	}
+	h.broadcast(RoomMessage{Users: userIDs, Data: data})
+}
+
+// CloseSession closes the connections userID opened with sessionID, on every
+// replica, once that session has been revoked.
+func (h *Hub) CloseSession(userID, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	h.broadcast(RoomMessage{UserID: userID, Close: true, SessionID: sessionID})
+}
+
+// CloseUser closes every connection of userID, on every replica.
+func (h *Hub) CloseUser(userID string) {
+	h.broadcast(RoomMessage{UserID: userID, Close: true})
 }
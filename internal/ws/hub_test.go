@@ -0,0 +1,147 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestClient returns a registered bare-frame client whose send channel
+// the test reads instead of a writePump.
+func newTestClient(t *testing.T, h *Hub, userID string, boundTo uint, rooms ...uint) *Client {
+	t.Helper()
+	c := &Client{
+		id:      lastClientID.Add(1),
+		hub:     h,
+		send:    make(chan []byte, 64),
+		done:    make(chan struct{}),
+		userID:  userID,
+		boundTo: boundTo,
+		rooms:   make(map[uint]bool),
+	}
+	for _, id := range rooms {
+		c.rooms[id] = true
+	}
+	h.register <- c
+	return c
+}
+
+// received drains what c got until the "end" marker, which the test
+// publishes to the user after the events under test.
+func received(t *testing.T, c *Client) []string {
+	t.Helper()
+	var out []string
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-c.send:
+			if string(data) == `"end"` {
+				return out
+			}
+			out = append(out, string(data))
+		case <-timeout:
+			t.Fatalf("marker not received, got %v", out)
+		}
+	}
+}
+
+func TestHubJoinRespectsBoundConversation(t *testing.T) {
+	const (
+		roomA uint = 1
+		roomB uint = 2
+		user       = "u1"
+	)
+	tests := []struct {
+		name    string
+		boundTo uint
+		want    []string
+	}{
+		{name: "bound socket ignores new conversation", boundTo: roomA, want: []string{`"a"`}},
+		{name: "unbound socket joins new conversation", boundTo: 0, want: []string{`"b"`, `"a"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(NewMemoryBroadcaster())
+			go h.Run()
+			defer h.GoAway()
+
+			c := newTestClient(t, h, user, tt.boundTo, roomA)
+			h.SubscribeToConversation(roomB, user)
+			h.PublishToConversation(roomB, "b")
+			h.PublishToConversation(roomA, "a")
+			h.PublishToUsers([]string{user}, "end")
+
+			got := received(t, c)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// settle returns once h has handled everything published before it, by
+// round-tripping a marker through the broadcaster to another client.
+func settle(t *testing.T, h *Hub) {
+	t.Helper()
+	c := newTestClient(t, h, "sync", 0)
+	h.PublishToUsers([]string{"sync"}, "end")
+	received(t, c)
+}
+
+func TestHubHoldsFramesDuringReplay(t *testing.T) {
+	const (
+		room uint = 1
+		user      = "u1"
+	)
+	tests := []struct {
+		name    string
+		events  int
+		dropped bool
+	}{
+		{name: "more than the send buffer", events: 200},
+		{name: "more than can be held", events: maxHeld + 1, dropped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(NewMemoryBroadcaster())
+			go h.Run()
+			defer h.GoAway()
+
+			c := &Client{
+				id:        lastClientID.Add(1),
+				hub:       h,
+				send:      make(chan []byte, 64),
+				done:      make(chan struct{}),
+				userID:    user,
+				rooms:     map[uint]bool{room: true},
+				replaying: true,
+			}
+			h.register <- c
+			for i := range tt.events {
+				h.PublishToConversation(room, i)
+			}
+			settle(t, h)
+
+			held := h.endReplay(c)
+			if tt.dropped {
+				if _, ok := <-c.send; ok {
+					t.Fatal("client kept after overflowing")
+				}
+				return
+			}
+			if len(held) != tt.events {
+				t.Fatalf("held %d frames, want %d", len(held), tt.events)
+			}
+
+			h.PublishToConversation(room, "live")
+			h.PublishToUsers([]string{user}, "end")
+			if got := received(t, c); len(got) != 1 || got[0] != `"live"` {
+				t.Fatalf("after replay got %v, want live frames in send", got)
+			}
+		})
+	}
+}
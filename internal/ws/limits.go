@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Config bounds what a single client may do with the socket.
+type Config struct {
+	// AllowedOrigins lists the browser origins (scheme://host[:port]) that
+	// may open a socket. "*" allows any. Empty means same origin only.
+	AllowedOrigins []string
+	// MaxMessageSize is the largest inbound frame in bytes; bigger frames
+	// close the socket with 1009.
+	MaxMessageSize int64
+
+	// MaxConnsPerUser and MaxConnsPerIP are counted by each replica on its
+	// own; behind N replicas a user or address can hold up to N times as
+	// many sockets.
+	MaxConnsPerUser int
+	MaxConnsPerIP   int
+
+	// FrameRate and FrameBurst size the token bucket applied to inbound
+	// frames. Clients that exhaust it are closed with 1008.
+	FrameRate  float64
+	FrameBurst int
+}
+
+// checkOrigin builds the upgrader's origin check. Requests without an Origin
+// header come from non-browser clients and are let through, as gorilla does.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		return nil // gorilla's default: same origin
+	}
+
+	set := make(map[string]bool, len(allowed))
+	for _, o := range allowed {
+		if o == "*" {
+			return func(r *http.Request) bool { return true }
+		}
+		set[strings.ToLower(strings.TrimRight(o, "/"))] = true
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		return set[strings.ToLower(u.Scheme+"://"+u.Host)]
+	}
+}
+
+// connLimiter counts open sockets per user and per IP. Counts are local to
+// this process; with several replicas each enforces its own caps.
+type connLimiter struct {
+	mu      sync.Mutex
+	perUser map[string]int
+	perIP   map[string]int
+
+	maxPerUser int
+	maxPerIP   int
+}
+
+func newConnLimiter(maxPerUser, maxPerIP int) *connLimiter {
+	return &connLimiter{
+		perUser:    make(map[string]int),
+		perIP:      make(map[string]int),
+		maxPerUser: maxPerUser,
+		maxPerIP:   maxPerIP,
+	}
+}
+
+// acquire reserves a slot, reporting false when either cap is reached. A cap
+// of zero disables it. Every successful acquire must be released.
+func (l *connLimiter) acquire(userID, ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxPerUser > 0 && l.perUser[userID] >= l.maxPerUser {
+		return false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.perUser[userID]++
+	l.perIP[ip]++
+	return true
+}
+
+func (l *connLimiter) release(userID, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perUser[userID]--; l.perUser[userID] <= 0 {
+		delete(l.perUser, userID)
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
@@ -0,0 +1,274 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+
+	"talk-backend/internal/http/response"
+	"talk-backend/internal/service"
+)
+
+// Subprotocol is offered in Sec-WebSocket-Protocol by clients speaking the
+// enveloped protocol. Connections that do not negotiate it keep exchanging
+// bare event objects, which are the envelope payloads on their own.
+const (
+	Subprotocol     = "talk.v1"
+	ProtocolVersion = 1
+)
+
+// CloseAuthExpired closes a socket whose access token expired without being
+// renewed by an "auth" frame.
+const CloseAuthExpired = 4001
+
+// CloseSessionRevoked closes the sockets of a session the user signed out
+// from another device.
+const CloseSessionRevoked = 4002
+
+// Envelope wraps every frame of the talk.v1 protocol. ID is chosen by the
+// client on requests and echoed on the ack or error answering them; server
+// pushes leave it empty.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Frame types that only exist on the socket. Conversation events use the
+// service.Event* names.
+const (
+	FrameSubscribe   = "subscribe"
+	FrameUnsubscribe = "unsubscribe"
+	FrameTyping      = "typing"
+	FramePresence    = "presence"
+	FrameResume      = "resume"
+	FrameAuth        = "auth"
+	FrameResumed     = "resumed"
+	FrameAck         = "ack"
+	FrameError       = "error"
+)
+
+// Inbound payloads.
+
+type SubscribePayload struct {
+	ConversationID uint `json:"conversationId"`
+}
+
+type TypingPayload struct {
+	ConversationID uint  `json:"conversationId"`
+	IsTyping       *bool `json:"isTyping"`
+}
+
+// ReceiptPayload is sent with the delivered and read types.
+type ReceiptPayload struct {
+	ConversationID uint `json:"conversationId"`
+	MessageID      uint `json:"messageId"`
+}
+
+type SendMessagePayload struct {
+	ConversationID  uint   `json:"conversationId"`
+	Content         string `json:"content,omitempty"`
+	ReplyTo         *uint  `json:"replyTo,omitempty"`
+	ThreadRoot      *uint  `json:"threadRoot,omitempty"`
+	AttachmentIDs   []uint `json:"attachmentIds,omitempty"`
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+}
+
+type PresencePayload struct {
+	Status string `json:"status"`
+}
+
+// ResumePayload maps conversation IDs to the newest message ID the client
+// holds.
+type ResumePayload struct {
+	LastSeen map[uint]uint `json:"lastSeen"`
+}
+
+// AuthPayload renews the access token a socket is bound to.
+type AuthPayload struct {
+	Token string `json:"token"`
+}
+
+func (p AuthPayload) validate() error {
+	if p.Token == "" {
+		return errInvalidFrame
+	}
+	return nil
+}
+
+func (p SubscribePayload) validate() error {
+	return validConversation(p.ConversationID)
+}
+
+func (p TypingPayload) validate() error {
+	if p.IsTyping == nil {
+		return errInvalidFrame
+	}
+	return validConversation(p.ConversationID)
+}
+
+func (p ReceiptPayload) validate() error {
+	if p.MessageID == 0 {
+		return &protocolError{Code: response.CodeInvalidRequest, Message: response.MsgInvalidMessage}
+	}
+	return validConversation(p.ConversationID)
+}
+
+func (p SendMessagePayload) validate() error {
+	if len(p.ClientMessageID) > maxClientID {
+		return &protocolError{Code: response.CodeInvalidRequest, Message: response.MsgInvalidClientID}
+	}
+	return validConversation(p.ConversationID)
+}
+
+func (p PresencePayload) validate() error {
+	if p.Status != service.PresenceOnline && p.Status != service.PresenceAway {
+		return &protocolError{Code: response.CodeInvalidRequest, Message: response.MsgInvalidPresence}
+	}
+	return nil
+}
+
+func (p ResumePayload) validate() error {
+	return nil
+}
+
+func validConversation(id uint) error {
+	if id == 0 {
+		return &protocolError{Code: response.CodeInvalidRequest, Message: response.MsgInvalidConversation}
+	}
+	return nil
+}
+
+// Outbound events specific to the socket.
+
+type TypingEvent struct {
+	Type           string `json:"type"`
+	ConversationID uint   `json:"conversationId"`
+	UserID         string `json:"userId"`
+	IsTyping       bool   `json:"isTyping"`
+}
+
+// AckEvent confirms a request. MessageID is set when a message was stored.
+type AckEvent struct {
+	Type            string `json:"type"`
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+	ConversationID  uint   `json:"conversationId,omitempty"`
+	MessageID       uint   `json:"messageId,omitempty"`
+}
+
+// ErrorEvent reports a rejected frame with the codes of the HTTP API.
+type ErrorEvent struct {
+	Type            string `json:"type"`
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+	ConversationID  uint   `json:"conversationId,omitempty"`
+	Code            string `json:"code"`
+	Message         string `json:"message"`
+}
+
+type ResumedEvent struct {
+	Type          string                `json:"type"`
+	Conversations []ResumedConversation `json:"conversations"`
+}
+
+type ResumedConversation struct {
+	ConversationID uint `json:"conversationId"`
+	// HasMore means the gap was larger than one replay; the client should
+	// page the rest over HTTP.
+	HasMore bool `json:"hasMore"`
+}
+
+// protocolError is turned into an error frame.
+type protocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *protocolError) Error() string {
+	return e.Message
+}
+
+var (
+	errInvalidFrame       = &protocolError{Code: response.CodeInvalidRequest, Message: response.MsgInvalidFrame}
+	errUnsupportedVersion = &protocolError{Code: response.CodeInvalidRequest, Message: response.MsgUnsupportedVersion}
+)
+
+// inbound is a client frame whose payload has not been decoded yet.
+type inbound struct {
+	Type string
+	ID   string
+
+	payload json.RawMessage
+	strict  bool
+}
+
+// parseFrame reads a frame of the given protocol. Bare frames are their own
+// payload; enveloped ones are decoded strictly so typos surface as errors.
+func parseFrame(protocol string, data []byte) (inbound, error) {
+	if protocol != Subprotocol {
+		var head struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &head); err != nil {
+			return inbound{}, errInvalidFrame
+		}
+		return inbound{Type: head.Type, payload: data}, nil
+	}
+
+	var env Envelope
+	if err := strictUnmarshal(data, &env); err != nil || env.Type == "" {
+		return inbound{}, errInvalidFrame
+	}
+	if env.V != ProtocolVersion {
+		return inbound{ID: env.ID}, errUnsupportedVersion
+	}
+	if len(env.Payload) == 0 {
+		env.Payload = json.RawMessage("{}")
+	}
+	return inbound{Type: env.Type, ID: env.ID, payload: env.Payload, strict: true}, nil
+}
+
+func (in inbound) decode(v interface{ validate() error }) error {
+	var err error
+	if in.strict {
+		err = strictUnmarshal(in.payload, v)
+	} else {
+		err = json.Unmarshal(in.payload, v)
+	}
+	if err != nil {
+		return errInvalidFrame
+	}
+	return v.validate()
+}
+
+func strictUnmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// encodeFrame serializes event for a connection speaking protocol. id is
+// echoed in the envelope when answering a request.
+func encodeFrame(protocol string, id string, event any) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if protocol != Subprotocol {
+		return data, nil
+	}
+	return wrap(data, id), nil
+}
+
+// wrap puts an encoded event into an envelope, taking the envelope type from
+// the event's own type field.
+func wrap(data []byte, id string) []byte {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		log.Printf("[WS] cannot read event type: %v", err)
+	}
+	out, _ := json.Marshal(Envelope{V: ProtocolVersion, Type: head.Type, ID: id, Payload: data})
+	return out
+}
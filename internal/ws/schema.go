@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"talk-backend/internal/service"
+)
+
+// frameSpec ties a frame type to the Go type of its payload.
+type frameSpec struct {
+	Type    string
+	Payload any
+}
+
+var clientFrames = []frameSpec{
+	{FrameSubscribe, SubscribePayload{}},
+	{FrameUnsubscribe, SubscribePayload{}},
+	{FrameTyping, TypingPayload{}},
+	{service.EventDelivered, ReceiptPayload{}},
+	{service.EventRead, ReceiptPayload{}},
+	{service.EventMessage, SendMessagePayload{}},
+	{FramePresence, PresencePayload{}},
+	{FrameResume, ResumePayload{}},
+	{FrameAuth, AuthPayload{}},
+}
+
+var serverFrames = []frameSpec{
+	{service.EventMessage, service.MessageEvent{}},
+	{service.EventMessageEdited, service.MessageEvent{}},
+	{service.EventMessageDeleted, service.MessageDeletedEvent{}},
+	{service.EventReactionAdded, service.ReactionEvent{}},
+	{service.EventReactionRemoved, service.ReactionEvent{}},
+	{service.EventDelivered, service.ReceiptEvent{}},
+	{service.EventRead, service.ReceiptEvent{}},
+	{service.EventMemberJoined, service.MemberEvent{}},
+	{service.EventMemberLeft, service.MemberEvent{}},
+	{service.EventMemberRemoved, service.MemberEvent{}},
+	{service.EventPresence, service.PresenceEvent{}},
+	{FrameTyping, TypingEvent{}},
+	{FrameAck, AckEvent{}},
+	{FrameError, ErrorEvent{}},
+	{FrameResumed, ResumedEvent{}},
+}
+
+// Schema describes the talk.v1 protocol as a JSON Schema (draft 2020-12).
+// $defs holds one union per direction, ClientFrame and ServerFrame, each
+// discriminated by the envelope type, so code generators can emit typed
+// unions. Client payloads are closed since the server rejects unknown
+// fields; server payloads may grow new fields.
+func Schema() map[string]any {
+	g := &schemaGen{defs: map[string]any{}}
+	g.defs["ClientFrame"] = g.union("Client", clientFrames, true)
+	g.defs["ServerFrame"] = g.union("Server", serverFrames, false)
+
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   Subprotocol,
+		"oneOf":   []any{ref("ClientFrame"), ref("ServerFrame")},
+		"$defs":   g.defs,
+	}
+}
+
+type schemaGen struct {
+	defs map[string]any
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+func (g *schemaGen) union(prefix string, frames []frameSpec, closed bool) map[string]any {
+	variants := make([]any, 0, len(frames))
+	for _, f := range frames {
+		variants = append(variants, map[string]any{
+			"title":    prefix + camel(f.Type),
+			"type":     "object",
+			"required": []string{"v", "type", "payload"},
+			"properties": map[string]any{
+				"v":       map[string]any{"const": ProtocolVersion},
+				"type":    map[string]any{"const": f.Type},
+				"id":      map[string]any{"type": "string"},
+				"payload": g.of(reflect.TypeOf(f.Payload), closed),
+			},
+			"additionalProperties": false,
+		})
+	}
+	return map[string]any{"oneOf": variants}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+func (g *schemaGen) of(t reflect.Type, closed bool) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.of(t.Elem(), closed)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.of(t.Elem(), closed)}
+	case reflect.Map:
+		s := map[string]any{"type": "object", "additionalProperties": g.of(t.Elem(), closed)}
+		if t.Key().Kind() != reflect.String {
+			s["propertyNames"] = map[string]any{"pattern": "^[0-9]+$"}
+		}
+		return s
+	case reflect.Struct:
+		return g.object(t, closed)
+	default:
+		return map[string]any{}
+	}
+}
+
+// object registers t under $defs by its Go name and returns a reference. A
+// closed type is registered apart, as NameClosed, so a struct used in both
+// directions gets both shapes.
+func (g *schemaGen) object(t reflect.Type, closed bool) map[string]any {
+	name := t.Name()
+	if closed {
+		name += "Closed"
+	}
+	if _, ok := g.defs[name]; ok {
+		return ref(name)
+	}
+	g.defs[name] = nil // placeholder, in case t refers to itself
+
+	props := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		field, opts, _ := strings.Cut(tag, ",")
+		if field == "" {
+			field = f.Name
+		}
+		props[field] = g.of(f.Type, closed)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, field)
+		}
+	}
+
+	s := map[string]any{"type": "object", "properties": props, "required": required}
+	if closed {
+		s["additionalProperties"] = false
+	}
+	g.defs[name] = s
+	return ref(name)
+}
+
+// camel turns "message_edited" into "MessageEdited".
+func camel(s string) string {
+	parts := strings.Split(s, "_")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+)
+
+type schemaPair struct {
+	A string `json:"a"`
+}
+
+func TestSchemaKeepsClosedAndOpenDefsApart(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []bool
+	}{
+		{name: "closed first", order: []bool{true, false}},
+		{name: "open first", order: []bool{false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &schemaGen{defs: map[string]any{}}
+			refs := map[bool]map[string]any{}
+			for _, closed := range tt.order {
+				refs[closed] = g.of(reflect.TypeOf(schemaPair{}), closed)
+			}
+			if reflect.DeepEqual(refs[true], refs[false]) {
+				t.Fatalf("closed and open share %v", refs[true])
+			}
+
+			closed := g.defs["schemaPairClosed"].(map[string]any)
+			if closed["additionalProperties"] != false {
+				t.Errorf("closed def allows additional properties: %v", closed)
+			}
+			open := g.defs["schemaPair"].(map[string]any)
+			if _, ok := open["additionalProperties"]; ok {
+				t.Errorf("open def forbids additional properties: %v", open)
+			}
+		})
+	}
+}
+
+func TestSchemaClientPayloadsAreClosed(t *testing.T) {
+	defs := Schema()["$defs"].(map[string]any)
+	for _, f := range clientFrames {
+		name := reflect.TypeOf(f.Payload).Name() + "Closed"
+		def, ok := defs[name].(map[string]any)
+		if !ok {
+			t.Errorf("%s: no $defs entry %s", f.Type, name)
+			continue
+		}
+		if def["additionalProperties"] != false {
+			t.Errorf("%s: payload %s is not closed", f.Type, name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"talk-backend/internal/http/middleware"
+	"talk-backend/internal/http/response"
+	"talk-backend/internal/service"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// SSEHandler streams the same events as the WebSocket for clients whose
+// network blocks upgrades. It registers with the hub like a socket would, so
+// fan-out, presence and backplane behave the same.
+type SSEHandler struct {
+	hub  *Hub
+	chat *service.ChatService
+}
+
+func NewSSEHandler(hub *Hub, chat *service.ChatService) *SSEHandler {
+	return &SSEHandler{hub: hub, chat: chat}
+}
+
+// sseResumed ends a replay. The stream replays every conversation at once,
+// so unlike ResumedEvent it has a single HasMore: the gap was larger than one
+// replay and the client should page the rest over HTTP.
+type sseResumed struct {
+	Type    string `json:"type"`
+	HasMore bool   `json:"hasMore"`
+}
+
+// Stream serves GET /api/events. Each event's data is the bare JSON payload a
+// WebSocket without the talk.v1 subprotocol would receive; there is no
+// envelope. Message events use the message ID as event ID, so after a
+// reconnect Last-Event-ID (or ?lastEventId= on a first request) replays what
+// was missed, followed by a {"type":"resumed","hasMore":bool} event.
+func (h *SSEHandler) Stream(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		response.Error(c, http.StatusUnauthorized, response.CodeUnauthorized, response.MsgUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("lastEventId")
+	}
+	var after uint64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			response.Error(c, http.StatusBadRequest, response.CodeInvalidRequest, response.MsgInvalidMessage)
+			return
+		}
+	}
+
+	rooms, err := h.chat.ConversationIDs(userID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal, response.MsgInternalServer)
+		return
+	}
+
+	client := &Client{
+		id:        lastClientID.Add(1),
+		hub:       h.hub,
+		send:      make(chan []byte, 64),
+		done:      make(chan struct{}),
+		userID:    userID,
+		sessionID: principal.SessionID,
+		rooms:     make(map[uint]bool, len(rooms)),
+	}
+	for _, id := range rooms {
+		client.rooms[id] = true
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stops nginx-style proxies from buffering the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// The stream outlives the server's WriteTimeout; pings detect dead peers.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	// As with WebSocket resume, register first so the hub holds live events
+	// back while the replay is written.
+	client.replaying = after > 0
+	h.hub.register <- client
+	defer func() { h.hub.unregister <- client }()
+	defer close(client.done)
+
+	if after > 0 {
+		if !h.replay(c, userID, uint(after)) {
+			return
+		}
+		for _, data := range h.hub.endReplay(client) {
+			c.Render(-1, sse.Event{Id: eventID(data), Data: data})
+		}
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+
+		case data, ok := <-client.send:
+			if !ok {
+				return
+			}
+			c.Render(-1, sse.Event{Id: eventID(data), Data: data})
+			c.Writer.Flush()
+
+		case <-ticker.C:
+			// A comment line keeps idle proxies from closing the stream.
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// replay sends messages newer than after, then a "resumed" event telling the
+// client whether it should page the rest over HTTP.
+func (h *SSEHandler) replay(c *gin.Context, userID string, after uint) bool {
+	msgs, err := h.chat.AllMissedMessages(userID, after, resumeLimit+1)
+	if err != nil {
+		return false
+	}
+	hasMore := len(msgs) > resumeLimit
+	if hasMore {
+		msgs = msgs[:resumeLimit]
+	}
+
+	for i := range msgs {
+		data, err := json.Marshal(service.MessageEvent{
+			Type:           service.EventMessage,
+			ConversationID: msgs[i].ConversationID,
+			Message:        service.NewMessagePayload(&msgs[i]),
+		})
+		if err != nil {
+			return false
+		}
+		c.Render(-1, sse.Event{Id: strconv.FormatUint(uint64(msgs[i].ID), 10), Data: data})
+	}
+
+	data, _ := json.Marshal(sseResumed{Type: FrameResumed, HasMore: hasMore})
+	c.Render(-1, sse.Event{Data: data})
+	return true
+}
+
+// eventID returns the message ID of "message" events. Other events have no
+// ID, so Last-Event-ID always points at the newest message received.
+func eventID(data []byte) string {
+	var ev struct {
+		Type    string `json:"type"`
+		Message struct {
+			ID uint `json:"id"`
+		} `json:"message"`
+	}
+	if json.Unmarshal(data, &ev) != nil || ev.Type != service.EventMessage || ev.Message.ID == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(ev.Message.ID), 10)
+}